@@ -1,42 +1,104 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
+	"runtime"
 
 	"github.com/mauidude/go-readability"
 	"github.com/spf13/cobra"
 )
 
-func main() {
+func newRootCmd() *cobra.Command {
 	var rootCmd = &cobra.Command{
-		Use:   "readability [file]",
+		Use:   "readability [file...]",
 		Short: "Readability is a CLI tool to extract content from an HTML page",
-		Args:  cobra.ExactArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			content, err := ioutil.ReadFile(args[0])
-			if err != nil {
-				return fmt.Errorf("unable to read file: %w", err)
-			}
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  run,
+	}
 
-			doc, err := readability.NewDocument(string(content))
-			if err != nil {
-				return fmt.Errorf("unable to create document: %w", err)
-			}
+	rootCmd.Flags().IntP("min-text-length", "l", 0, "minimum text length to consider a node")
+	rootCmd.Flags().BoolP("text", "t", false, "output plain text instead of HTML")
+	rootCmd.Flags().IntP("wrap", "w", 0, "wrap plain text output at the given column, implies --text")
+	rootCmd.Flags().Bool("reading-time", false, "print the estimated reading time and word count to stderr")
+	rootCmd.Flags().Bool("progress", false, "print a line to stderr as each file is extracted")
 
-			doc.MinTextLength, _ = cmd.Flags().GetInt("min-text-length")
+	return rootCmd
+}
 
-			html := doc.Content()
-			fmt.Println(html)
+// run extracts the content of each file concurrently, writing it to stdout in
+// the order the files were given. Every file is extracted with the same
+// options, however many there are.
+func run(cmd *cobra.Command, files []string) error {
+	minTextLength, _ := cmd.Flags().GetInt("min-text-length")
+	wrap, _ := cmd.Flags().GetInt("wrap")
+	text, _ := cmd.Flags().GetBool("text")
+	readingTime, _ := cmd.Flags().GetBool("reading-time")
+	progress, _ := cmd.Flags().GetBool("progress")
 
-			return nil
-		},
+	inputs := make([]string, len(files))
+	for i, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("unable to read file: %w", err)
+		}
+
+		inputs[i] = string(content)
 	}
 
-	rootCmd.Flags().IntP("min-text-length", "l", 0, "minimum text length to consider a node")
+	configure := func(doc *readability.Document) {
+		doc.MinTextLength = minTextLength
+		doc.WrapWidth = wrap
+	}
+
+	done := 0
+	report := func(i int, err error) {
+		if !progress {
+			return
+		}
+
+		done++
+		if err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "[%d/%d] %s: %v\n", done, len(files), files[i], err)
+		} else {
+			fmt.Fprintf(cmd.ErrOrStderr(), "[%d/%d] %s\n", done, len(files), files[i])
+		}
+	}
+
+	results, err := readability.ExtractManyWithProgress(context.Background(), inputs, runtime.NumCPU(), report, configure)
+	for i, result := range results {
+		if result == nil {
+			continue
+		}
+
+		if text || wrap > 0 {
+			fmt.Fprintln(cmd.OutOrStdout(), result.Text)
+		} else {
+			fmt.Fprintln(cmd.OutOrStdout(), result.Content)
+		}
+
+		// written to stderr so the extracted content can still be piped
+		if readingTime {
+			minutes := int(math.Ceil(result.ReadingTime.Minutes()))
+			if len(files) > 1 {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%s: ", files[i])
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "%d min read, %d words\n", minutes, result.WordCount)
+		}
+	}
 
-	if err := rootCmd.Execute(); err != nil {
+	if err != nil {
+		return fmt.Errorf("unable to extract files: %w", err)
+	}
+
+	return nil
+}
+
+func main() {
+	if err := newRootCmd().Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
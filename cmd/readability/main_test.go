@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestReadingTimeFlag(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := newRootCmd()
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs([]string{"--reading-time", "../../test_fixtures/channel4-1.html"})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatal("Unable to run command", err)
+	}
+
+	if !strings.Contains(stdout.String(), "force-feeding") {
+		t.Errorf("Expected the content on stdout, got %q", stdout.String())
+	}
+
+	if strings.Contains(stdout.String(), "min read") {
+		t.Errorf("Expected the reading time to be left out of stdout, got %q", stdout.String())
+	}
+
+	if !regexp.MustCompile(`^1 min read, \d+ words\n$`).MatchString(stderr.String()) {
+		t.Errorf("Expected the reading time on stderr, got %q", stderr.String())
+	}
+}
+
+func TestProgressFlag(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	files := []string{"../../test_fixtures/channel4-1.html", "../../test_fixtures/rtl.html"}
+
+	cmd := newRootCmd()
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs(append([]string{"--progress"}, files...))
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatal("Unable to run command", err)
+	}
+
+	out := stdout.String()
+	if !strings.Contains(out, "force-feeding") || !strings.Contains(out, "مكتبة") {
+		t.Errorf("Expected the content of both files on stdout, got %q", out)
+	}
+
+	if strings.Index(out, "force-feeding") > strings.Index(out, "مكتبة") {
+		t.Errorf("Expected the content in the order the files were given, got %q", out)
+	}
+
+	if strings.Contains(out, "[1/2]") || strings.Contains(out, "[2/2]") {
+		t.Errorf("Expected the progress to be left out of stdout, got %q", out)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected a progress line per file on stderr, got %q", stderr.String())
+	}
+
+	for i, line := range lines {
+		if !regexp.MustCompile(fmt.Sprintf(`^\[%d/2\] \.\./\.\./test_fixtures/(channel4-1|rtl)\.html$`, i+1)).MatchString(line) {
+			t.Errorf("Unexpected progress line %q", line)
+		}
+	}
+}
+
+func TestBatchMatchesSingleFile(t *testing.T) {
+	execute := func(args ...string) (string, string) {
+		var stdout, stderr bytes.Buffer
+
+		cmd := newRootCmd()
+		cmd.SetOut(&stdout)
+		cmd.SetErr(&stderr)
+		cmd.SetArgs(args)
+
+		if err := cmd.Execute(); err != nil {
+			t.Fatal("Unable to run command", err)
+		}
+
+		return stdout.String(), stderr.String()
+	}
+
+	files := []string{"../../test_fixtures/hero_image.html", "../../test_fixtures/base_href.html", "../../test_fixtures/rtl.html"}
+
+	for _, flags := range [][]string{nil, {"--text"}, {"--wrap", "60"}, {"--min-text-length", "25"}} {
+		batch, _ := execute(append(flags, files...)...)
+
+		single := ""
+		for _, file := range files {
+			out, _ := execute(append(flags, file)...)
+			single += out
+		}
+
+		if batch != single {
+			t.Errorf("Expected the output with %q to be the same for one file at a time and all at once, got %q and %q", flags, single, batch)
+		}
+	}
+
+	_, stderr := execute(append([]string{"--reading-time"}, files...)...)
+	for _, file := range files {
+		if !regexp.MustCompile(regexp.QuoteMeta(file) + `: \d+ min read, \d+ words\n`).MatchString(stderr) {
+			t.Errorf("Expected the reading time of %s on stderr, got %q", file, stderr)
+		}
+	}
+}
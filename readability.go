@@ -2,234 +2,3653 @@ package readability
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"io/ioutil"
 	"log"
 	"math"
+	"net/http"
+	"net/url"
+	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/PuerkitoBio/goquery"
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 var (
 	Logger = log.New(ioutil.Discard, "[readability] ", log.LstdFlags)
 
-	replaceBrsRegexp   = regexp.MustCompile(`(?i)(<br[^>]*>[ \n\r\t]*){2,}`)
-	replaceFontsRegexp = regexp.MustCompile(`(?i)<(\/?)\s*font[^>]*?>`)
+	blacklistCandidatesRegexp  = regexp.MustCompile(`(?i)popupbody`)
+	okMaybeItsACandidateRegexp = regexp.MustCompile(`(?i)and|article|body|column|main|shadow`)
+	heroWrapperRegexp          = regexp.MustCompile(`(?i)header|hero`)
+	unlikelyCandidatesRegexp   = regexp.MustCompile(`(?i)combx|comment|community|hidden|disqus|modal|extra|foot|header|menu|remark|rss|shoutbox|sidebar|sponsor|ad-break|agegate|pagination|pager|popup`)
+	navigationSelector         = "nav,aside,[role=navigation],[role=complementary]"
+	xhtmlRegexp                = regexp.MustCompile(`(?i)^\s*<\?xml|xmlns\s*=\s*["']http://www\.w3\.org/1999/xhtml`)
+	selfClosedRawTextRegexp    = regexp.MustCompile(`(?i)<((?:[\w-]+:)?(?:iframe|noembed|noframes|noscript|script|style|textarea|title|xmp))(\s[^<>]*?)?\s*/>`)
+	divToPElementsRegexp       = regexp.MustCompile(`(?i)<(a|blockquote|dl|div|img|ol|p|pre|table|ul)`)
+
+	negativeRegexp = regexp.MustCompile(`(?i)combx|comment|com-|foot|footer|footnote|masthead|media|meta|outbrain|promo|related|scroll|shoutbox|sidebar|sponsor|shopping|tags|tool|widget`)
+	positiveRegexp = regexp.MustCompile(`(?i)article|body|content|entry|hentry|main|page|pagination|post|text|blog|story`)
+
+	sentenceRegexp = regexp.MustCompile(`\.( |$)|[。．！？]`)
+
+	normalizeWhitespaceRegexp = regexp.MustCompile(`[\r\n\f]+`)
+	blankLinesRegexp          = regexp.MustCompile(`\s*\n\s*`)
+	spaceRunRegexp            = regexp.MustCompile(`[\s\x{00a0}]{2,}`)
+
+	imageCreditSelector = ".credit,.photo-credit,.image-credit"
+	captionSelector     = "figcaption,caption"
+
+	pullQuoteSelector = ".pullquote,.pull-quote,.pullout,.pull-out"
+
+	subtitleSelector = ".subtitle,.deck,.dek,[itemprop=alternativeHeadline]"
+
+	authorImageRegexp = regexp.MustCompile(`(?i)avatar|author-photo`)
+	imageURLRegexp    = regexp.MustCompile(`(?i)\.(jpe?g|png|gif|webp|avif|svg)(\?|$)`)
+
+	paywallRegexp = regexp.MustCompile(`(?i)paywall|subscription|premium-gate|metered`)
+
+	hiddenRegexp      = regexp.MustCompile(`(?i)hidden`)
+	hiddenStyleRegexp = regexp.MustCompile(`(?i)display\s*:\s*none|visibility\s*:\s*hidden`)
+	boldStyleRegexp   = regexp.MustCompile(`(?i)font-weight\s*:\s*(bold|bolder|[6-9]00)`)
+	italicStyleRegexp = regexp.MustCompile(`(?i)font-style\s*:\s*(italic|oblique)`)
+
+	columnRegexp = regexp.MustCompile(`(?i)(^|[^a-z])col(umn)?s?([^a-z]|$)`)
+
+	sentenceEndRegexp = regexp.MustCompile(`[.!?。！？]+(\s|$)`)
+
+	imageDimensionRegexp = regexp.MustCompile(`^\s*(\d+)\s*(px)?\s*$`)
+
+	liveBlogSelector = ".liveblog-entry,article[data-timestamp]"
+
+	// MathJax keeps the LaTeX source in scripts and renders it alongside
+	mathJaxScriptSelector   = `script[type^="math/tex"]`
+	mathJaxRenderedSelector = ".MathJax_Preview,.MathJax,.MathJax_Display,.MathJax_SVG,.MathJax_CHTML"
+
+	mathAttributes = []string{"display", "mathvariant", "encoding", "stretchy", "fence", "separator", "accent", "linethickness", "columnalign", "rowalign"}
+
+	archiveChromeSelector = "#wm-ipp-base,#wm-ipp,#wm-ipp-print,#wm-capinfo,#donato,.wb-autocomplete-suggestions"
+	archiveURLRegexp      = regexp.MustCompile(`^(https?:)?(//web\.archive\.org)?/web/\d+([a-z]{2}_)?/`)
+
+	socialEmbedSelector = "blockquote.twitter-tweet,blockquote.twitter-video,blockquote.instagram-media,blockquote.tiktok-embed,.fb-post,.fb-video"
+
+	videoEmbedRegexp = regexp.MustCompile(`(?i)^(https?:)?//(www\.)?(youtube(-nocookie)?\.com/embed/|player\.vimeo\.com/video/|dailymotion\.com/embed/|players\.brightcove\.net/)`)
+
+	footnotesSelector     = ".footnotes,.endnotes,#footnotes,#endnotes,[role=doc-endnotes]"
+	footnoteRefRegexp     = regexp.MustCompile(`(?i)^#(fn|footnote|note|endnote)[-_:]?\d+$`)
+	footnoteBackrefRegexp = regexp.MustCompile(`(?i)^#(fnref|footnote-ref|noteref)[-_:]?\d+$`)
+)
+
+// ErrRecipeNotFound is returned by Recipe when the page has no recipe markup.
+var ErrRecipeNotFound = errors.New("no recipe markup found")
+
+// Recipe is the structured recipe data embedded in a page. Times are ISO 8601
+// durations as given by the page, e.g. PT30M.
+type Recipe struct {
+	Name         string
+	Ingredients  []string
+	Instructions []string
+	PrepTime     string
+	CookTime     string
+	TotalTime    string
+	Yield        string
+}
+
+// ScoreBreakdown is how a candidate's content score was arrived at, following
+// the original arc90 readability formula:
+//
+//	Score = (TagScore + ClassWeight + ParagraphBonus + CommaBonus + LengthBonus) * LinkDensityMultiplier + ArticleBonus
+//
+// The bonuses of paragraphs count fully towards their parent and half towards
+// their grandparent. ArticleBonus, which arc90 doesn't have, is given to a
+// lone <article> that is too short to be taken as the article outright.
+type ScoreBreakdown struct {
+	TagScore              float32
+	ClassWeight           float32
+	ParagraphBonus        float32
+	CommaBonus            float32
+	LengthBonus           float32
+	LinkDensityMultiplier float32
+	ArticleBonus          float32
+	Score                 float32
+}
+
+// Metadata is the social preview metadata of a page, taken from its OpenGraph
+// tags with gaps filled from its Twitter card tags.
+type Metadata struct {
+	Title       string
+	Description string
+	Image       string
+	URL         string
+	SiteName    string
+	Type        string
+	Card        string
+	Creator     string
+}
+
+type candidate struct {
+	selection *goquery.Selection
+	score     float32
+	breakdown ScoreBreakdown
+}
+
+func (c *candidate) Node() *html.Node {
+	return c.selection.Get(0)
+}
+
+type Document struct {
+	input         string
+	document      *goquery.Document
+	source        *goquery.Document
+	content       string
+	title         string
+	candidates    map[*html.Node]*candidate
+	bestCandidate *candidate
+	semantic      bool
+	articleNodes  []*html.Node
+	boilerplate   *BoilerplateFilter
+	lastModified  time.Time
+	baseHref      *url.URL
+	parsed        string
+	indexTags     bool
+	sourceTags    map[*html.Node]sourceTag
+
+	RemoveUnlikelyCandidates       bool
+	WeightClasses                  bool
+	CleanConditionally             bool
+	BestCandidateHasImage          bool
+	RetryLength                    int
+	RetryStrategy                  []RetryStep
+	MaxParseBytes                  int
+	MinTextLength                  int
+	MinImageWidth                  int
+	MinImageHeight                 int
+	SiblingParagraphMinLength      int
+	SiblingParagraphMaxLinkDensity float32
+	MaxCandidateLinkDensity        float32
+	MaxSiblingAppends              int
+	CommaWeight                    float32
+	LengthBonusCap                 int
+	TagScores                      map[string]int
+	SentenceRegexp                 *regexp.Regexp
+	RemoveEmptyNodes               bool
+	RemoveEmptyElements            bool
+	WhitelistTags                  []string
+	NonContentTags                 []string
+	CustomBlockTags                []string
+	JunkPhrases                    []string
+	TrackingParams                 []string
+	PreserveHorizontalRules        bool
+	PreserveScientificMarkup       bool
+	PreserveMath                   bool
+	PreserveHighlights             bool
+	PreserveAbbreviations          bool
+	KeepTableCaptions              bool
+	MergeShortParagraphs           bool
+	ShortParagraphLength           int
+	PreserveSemanticSpans          bool
+	InferSemanticsFromStyle        bool
+	StripImageCredits              bool
+	TableLayoutMode                bool
+	WrapWidth                      int
+	NormalizePunctuation           bool
+	UseAccessibleText              bool
+	StripArchiveChrome             bool
+	SemanticOutput                 bool
+	IncludeTitleInBody             bool
+	IncludeSubtitleInBody          bool
+	OutputFormat                   string
+	PreserveDirection              bool
+	MergeColumns                   bool
+	StripTrackingParams            bool
+	PreserveTimeElements           bool
+	PreserveAnchors                bool
+	StripDecorativeParagraphs      bool
+	IncludeHiddenContent           bool
+	CompactWhitespace              bool
+	PreserveNonBreakingSpaces      bool
+	ExtractLiveBlog                bool
+	BaseURL                        *url.URL
+	ForceContentRegexp             *regexp.Regexp
+	ForceRemoveRegexp              *regexp.Regexp
+	RemoveTrailingBoilerplate      bool
+	TrailingBoilerplateRegexp      *regexp.Regexp
+	OnPhase                        func(phase string, dur time.Duration)
+	EnableDensityFallback          bool
+	PreserveFootnotes              bool
+}
+
+// Option adjusts the configuration of a Document.
+type Option func(*Document)
+
+// RetryStep relaxes the configuration of a Document when the extracted content
+// is shorter than RetryLength. It reports whether it changed anything, so the
+// next step is tried once it has nothing left to relax.
+type RetryStep func(*Document) bool
+
+// KeepUnlikelyCandidates is a RetryStep that stops removing unlikely
+// candidates.
+func KeepUnlikelyCandidates(d *Document) bool {
+	changed := d.RemoveUnlikelyCandidates
+	d.RemoveUnlikelyCandidates = false
+	return changed
+}
+
+// IgnoreClassWeights is a RetryStep that stops weighting classes and ids.
+func IgnoreClassWeights(d *Document) bool {
+	changed := d.WeightClasses
+	d.WeightClasses = false
+	return changed
+}
+
+// SkipConditionalCleaning is a RetryStep that stops cleaning conditionally.
+func SkipConditionalCleaning(d *Document) bool {
+	changed := d.CleanConditionally
+	d.CleanConditionally = false
+	return changed
+}
+
+// NewDocument parses the page s. opts are applied before the page is parsed,
+// so an OnPhase set by them also reports the "parse" phase.
+func NewDocument(s string, opts ...Option) (*Document, error) {
+	d := newDocument(s)
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	err := d.initializeHtml(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// NewDocumentFromGoquery creates a Document from a page that has already been
+// parsed, skipping the clean up of <br>s, <font>s and comments done when
+// parsing a string. Extraction modifies the document in place, removing and
+// renaming elements, so callers that still need it afterwards should pass a
+// copy. Retries, and metadata such as Metadata and PublishedDate, use a
+// rendering of the document taken before it is modified. opts are applied
+// before the document is used, as with NewDocument.
+func NewDocumentFromGoquery(doc *goquery.Document, opts ...Option) (*Document, error) {
+	if doc == nil {
+		return nil, errors.New("nil goquery document")
+	}
+
+	s, err := goquery.OuterHtml(doc.Selection)
+	if err != nil {
+		return nil, err
+	}
+
+	d := newDocument(s)
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.parsed = s
+
+	start := d.phaseStart()
+	err = d.useDocument(doc)
+	d.phaseDone("parse", start)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// newDocument returns a Document for the input s with the default options,
+// but without a parsed document.
+func newDocument(s string) *Document {
+	return &Document{
+		input:                          s,
+		WhitelistTags:                  []string{"div", "p"},
+		NonContentTags:                 []string{"script", "style", "noscript", "template"},
+		TrackingParams:                 []string{"utm_*", "fbclid", "gclid"},
+		RemoveUnlikelyCandidates:       true,
+		WeightClasses:                  true,
+		CleanConditionally:             true,
+		RetryLength:                    250,
+		RetryStrategy:                  []RetryStep{KeepUnlikelyCandidates, IgnoreClassWeights, SkipConditionalCleaning},
+		MinTextLength:                  25,
+		SiblingParagraphMinLength:      80,
+		SiblingParagraphMaxLinkDensity: .25,
+		ShortParagraphLength:           80,
+		MaxCandidateLinkDensity:        1,
+		CommaWeight:                    1,
+		LengthBonusCap:                 3,
+		TagScores: map[string]int{
+			"div":        5,
+			"section":    3,
+			"blockquote": 3,
+			"form":       3,
+			"fieldset":   3,
+			"th":         -5,
+		},
+		SentenceRegexp:            sentenceRegexp,
+		RemoveEmptyNodes:          true,
+		TrailingBoilerplateRegexp: regexp.MustCompile(`(?i)author-bio|social|share|tags`),
+	}
+}
+
+// NewDocumentFromReader reads the page from r. opts are applied before the page
+// is parsed, so MaxParseBytes can be set to read no more than that many bytes
+// of a huge page, trading the rest of the page for bounded latency. The page
+// is cut before any tag left unfinished.
+func NewDocumentFromReader(r io.Reader, opts ...Option) (*Document, error) {
+	d := newDocument("")
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	if d.MaxParseBytes > 0 {
+		r = io.LimitReader(r, int64(d.MaxParseBytes))
+	}
+
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	s := string(body)
+	if d.MaxParseBytes > 0 && len(body) == d.MaxParseBytes {
+		s = truncateHtml(s)
+	}
+
+	d.input = s
+
+	err = d.initializeHtml(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// truncateHtml drops the end of s if it was cut off in the middle of a tag or
+// a multi-byte character.
+func truncateHtml(s string) string {
+	for i := 0; i < utf8.UTFMax && len(s) > 0; i++ {
+		if r, size := utf8.DecodeLastRuneInString(s); r != utf8.RuneError || size != 1 {
+			break
+		}
+		s = s[:len(s)-1]
+	}
+
+	if i := strings.LastIndexByte(s, '<'); i > strings.LastIndexByte(s, '>') {
+		s = s[:i]
+	}
+
+	return s
+}
+
+// NewDocumentFromURL fetches the page at rawurl and creates a Document from
+// it, with BaseURL set to the final URL of the page. The request is abandoned
+// once ctx is done, so callers should give it a deadline. opts are applied
+// before the page is read, as with NewDocumentFromReader, so MaxParseBytes
+// also bounds how much of the response body is read.
+func NewDocumentFromURL(ctx context.Context, rawurl string, opts ...Option) (*Document, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status fetching %s: %s", rawurl, resp.Status)
+	}
+
+	d, err := NewDocumentFromReader(resp.Body, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	d.BaseURL = resp.Request.URL
+
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		if t, err := http.ParseTime(lastModified); err == nil {
+			d.lastModified = t
+		} else {
+			Logger.Printf("Unable to parse Last-Modified %s\n", err)
+		}
+	}
+
+	return d, nil
+}
+
+func (d *Document) initializeHtml(s string) error {
+	defer d.phaseDone("parse", d.phaseStart())
+
+	raw := s
+	s, err := preprocessHtml(s)
+	if err != nil {
+		return err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(s))
+	if err != nil {
+		return err
+	}
+
+	d.parsed = raw
+	return d.useDocument(doc)
+}
+
+// useDocument makes doc the document to extract from, unless it has no body
+// or the page is embedded in an iframe, in which case the replacement is
+// parsed instead.
+func (d *Document) useDocument(doc *goquery.Document) error {
+	// if no body (like from a redirect or empty string)
+	if doc.Find("body").Length() == 0 {
+		return d.initializeHtml("<body/>")
+	}
+
+	// archived pages sometimes embed the real page in an iframe's srcdoc,
+	// leaving nothing else in the body
+	body := doc.Find("body").First()
+	if iframe := body.Find("iframe[srcdoc]"); iframe.Length() == 1 {
+		outside := len(strings.TrimSpace(body.Text())) - len(strings.TrimSpace(iframe.Text()))
+		if outside < d.MinTextLength {
+			Logger.Println("Using iframe srcdoc as the document")
+			srcdoc, _ := iframe.Attr("srcdoc")
+			return d.initializeHtml(srcdoc)
+		}
+	}
+
+	d.baseHref = nil
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if u, err := url.Parse(strings.TrimSpace(href)); err == nil {
+			d.baseHref = u
+		} else {
+			Logger.Printf("Unable to parse base URL %s\n", err)
+		}
+	}
+
+	d.document = doc
+	if d.indexTags {
+		d.sourceTags = indexSourceTags(doc.Get(0))
+	}
+	return nil
+}
+
+// sourceTag identifies an element by its tag name and how many elements with
+// that name come before it, which is enough to find it again in the markup.
+type sourceTag struct {
+	name  string
+	index int
+}
+
+// indexSourceTags records the sourceTag of every element within root before
+// extraction renames and removes them.
+func indexSourceTags(root *html.Node) map[*html.Node]sourceTag {
+	tags := make(map[*html.Node]sourceTag)
+	counts := make(map[string]int)
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			tags[n] = sourceTag{name: n.Data, index: counts[n.Data]}
+			counts[n.Data]++
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+
+	return tags
+}
+
+// ArticleOffsets returns the byte offsets in the input HTML where the element
+// holding the article starts and ends, for highlighting it in the original
+// page. The element is found again by tag name and position, so the offsets
+// are an estimate on badly formed pages, where the parser adds or moves
+// elements, and ok is false if it cannot be found at all, or the page was
+// embedded in an iframe's srcdoc. The page is extracted again to find the
+// element, so this is as slow as calling Content.
+func (d *Document) ArticleOffsets() (start, end int, ok bool) {
+	c := d.withOptions(func(c *Document) {
+		c.indexTags = true
+	})
+
+	if err := c.initializeHtml(c.input); err != nil {
+		Logger.Println("Unable to parse document", err)
+		return 0, 0, false
+	}
+
+	c.Content()
+
+	if c.bestCandidate == nil || c.parsed != c.input {
+		return 0, 0, false
+	}
+
+	tag, ok := c.sourceTags[c.bestCandidate.Node()]
+	if !ok {
+		return 0, 0, false
+	}
+
+	return sourceOffsets(c.input, tag)
+}
+
+// sourceOffsets finds the element identified by tag in markup, returning the
+// offset of its start tag and the offset just after its end tag. Elements
+// left open end where their parent does.
+func sourceOffsets(markup string, tag sourceTag) (start, end int, ok bool) {
+	z := html.NewTokenizer(strings.NewReader(markup))
+
+	offset, count := 0, 0
+	start = -1
+	var open []string
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if start >= 0 {
+				return start, len(markup), true
+			}
+			return 0, 0, false
+		}
+
+		length := len(z.Raw())
+		tagName, _ := z.TagName()
+		name := string(tagName)
+
+		// preprocessHtml turns <font> into <span>, and drops XHTML namespace
+		// prefixes, before parsing
+		if i := strings.IndexByte(name, ':'); i >= 0 {
+			name = name[i+1:]
+		}
+		if name == "font" {
+			name = "span"
+		}
+
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if start >= 0 {
+				if tt == html.StartTagToken && !voidTags[name] {
+					open = append(open, name)
+				}
+			} else if name == tag.name {
+				if count == tag.index {
+					if tt == html.SelfClosingTagToken || voidTags[name] {
+						return offset, offset + length, true
+					}
+
+					start = offset
+					open = []string{name}
+				}
+				count++
+			}
+		case html.EndTagToken:
+			if start >= 0 {
+				i := len(open) - 1
+				for i >= 0 && open[i] != name {
+					i--
+				}
+
+				switch {
+				case i == 0:
+					return start, offset + length, true
+				case i < 0:
+					// closes an ancestor, so the element was left open
+					return start, offset, true
+				default:
+					open = open[:i]
+				}
+			}
+		}
+
+		offset += length
+	}
+}
+
+// preprocessHtml strips comments, replaces consecutive <br>'s with p tags and
+// replaces font tags with spans. It works on tokens rather than the raw string
+// so that text within raw text elements such as <script> is left untouched.
+// XHTML is tidied up for the HTML parser, see xhtmlToken.
+func preprocessHtml(s string) (string, error) {
+	output := bytes.NewBuffer(make([]byte, 0, len(s)))
+
+	head := s
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	xhtml := xhtmlRegexp.MatchString(head)
+
+	// the tokenizer reads everything after a <script/> or <title/> as its
+	// text, up to a closing tag that never comes, so close them first
+	if xhtml {
+		s = selfClosedRawTextRegexp.ReplaceAllString(s, "<$1$2></$1>")
+	}
+
+	z := html.NewTokenizer(strings.NewReader(s))
+
+	// consecutive <br>'s and the whitespace between them are held back until
+	// we know how many there are
+	var pending []byte
+	brs := 0
+
+	flush := func() {
+		if brs >= 2 {
+			output.WriteString("</p><p>")
+		} else {
+			output.Write(pending)
+		}
+
+		pending = pending[:0]
+		brs = 0
+	}
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			flush()
+
+			if err := z.Err(); err != io.EOF {
+				return "", err
+			}
+
+			return output.String(), nil
+		}
+
+		// copy the raw token as calls to TagName modify the underlying buffer
+		raw := append([]byte(nil), z.Raw()...)
+
+		switch tt {
+		case html.CommentToken:
+			// the html parser keeps comments around, so drop them here
+			continue
+		case html.TextToken:
+			if brs > 0 && strings.Trim(string(raw), " \n\r\t") == "" {
+				pending = append(pending, raw...)
+				continue
+			}
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			var name []byte
+			if xhtml {
+				var tag string
+				tag, raw = xhtmlToken(z.Token())
+				name = []byte(tag)
+			} else {
+				name, _ = z.TagName()
+			}
+
+			switch string(name) {
+			case "br":
+				if tt != html.EndTagToken {
+					pending = append(pending, raw...)
+					brs++
+					continue
+				}
+			case "font":
+				flush()
+				if tt == html.EndTagToken {
+					output.WriteString("</span>")
+				} else {
+					output.WriteString("<span>")
+				}
+				continue
+			}
+		}
+
+		flush()
+		output.Write(raw)
+	}
+}
+
+// xhtmlToken rewrites a tag from an XHTML page so the HTML parser reads it as
+// the browser would: namespace prefixes are dropped from names, namespace
+// declarations are dropped, and self-closed elements such as <div/> or
+// <script/>, which HTML would leave open, are closed. It returns the tag's
+// name along with the rewritten tag.
+func xhtmlToken(t html.Token) (string, []byte) {
+	if i := strings.IndexByte(t.Data, ':'); i >= 0 {
+		t.Data = t.Data[i+1:]
+	}
+
+	attrs := make([]html.Attribute, 0, len(t.Attr))
+	for _, attr := range t.Attr {
+		if attr.Key == "xmlns" || strings.HasPrefix(attr.Key, "xmlns:") {
+			continue
+		}
+
+		if i := strings.IndexByte(attr.Key, ':'); i >= 0 {
+			attr.Key = attr.Key[i+1:]
+		}
+		attrs = append(attrs, attr)
+	}
+	t.Attr = attrs
+
+	if t.Type == html.SelfClosingTagToken && !voidTags[t.Data] {
+		t.Type = html.StartTagToken
+		return t.Data, []byte(t.String() + "</" + t.Data + ">")
+	}
+
+	return t.Data, []byte(t.String())
+}
+
+func (d *Document) Content() string {
+	if d.content == "" {
+		// web stories have no article to score, just pages of text
+		if story := d.document.Find("amp-story").First(); story.Length() > 0 {
+			d.removeNonContentTags()
+			d.content = d.finishContent(d.sanitize(d.getWebStory(story)))
+			return d.content
+		}
+
+		d.cleanDocument()
+
+		// live blogs are a series of entries rather than a single article
+		if d.ExtractLiveBlog {
+			if entries := d.liveBlogEntries(); entries.Length() > 1 {
+				d.content = d.finishContent(d.sanitize(d.getLiveBlog(entries)))
+				return d.content
+			}
+		}
+
+		d.prepareCandidates()
+
+		article := d.getArticle()
+
+		// falling back to the whole body means the heuristics found nothing,
+		// so try the densest run of text instead
+		if d.EnableDensityFallback && d.bestCandidate.selection.Is("body") {
+			if dense := d.getDensestRegion(); dense != "" {
+				Logger.Println("Using the densest region of text as the article")
+				article = dense
+			}
+		}
+
+		start := d.phaseStart()
+		articleText := d.sanitize(article)
+		d.phaseDone("sanitize", start)
+
+		length := len(strings.TrimSpace(articleText))
+		if length < d.RetryLength {
+			for _, step := range d.RetryStrategy {
+				if step(d) {
+					Logger.Printf("Retrying with length %d < retry length %d\n", length, d.RetryLength)
+					d.initializeHtml(d.input)
+					return d.Content()
+				}
+			}
+
+			if d.EnableDensityFallback {
+				if dense := d.getDensestRegion(); dense != "" {
+					denseText := d.sanitize(dense)
+					if len(strings.TrimSpace(denseText)) > length {
+						Logger.Println("Using the densest region of text as the article")
+						articleText = denseText
+					}
+				}
+			}
+		}
+
+		d.content = d.finishContent(articleText)
+	}
+
+	return d.content
+}
+
+// finishContent applies the output options to the sanitized article.
+func (d *Document) finishContent(text string) string {
+	if d.SemanticOutput {
+		text = d.semanticOutput(text)
+	}
+
+	if d.IncludeTitleInBody {
+		text = d.includeTitle(text)
+	}
+
+	if d.IncludeSubtitleInBody {
+		text = d.includeSubtitle(text)
+	}
+
+	if d.PreserveDirection && d.Direction() == "rtl" {
+		text = rtlOutput(text)
+	}
+
+	if d.OutputFormat == "xhtml" {
+		text = xhtmlOutput(text)
+	}
+
+	return text
+}
+
+// Direction returns "rtl" if the page is written right to left, such as in
+// Arabic or Hebrew, and "ltr" otherwise. The dir attribute of the page's
+// <html> or <body> is used if there is one, or else the direction of most of
+// the letters in the page.
+func (d *Document) Direction() string {
+	doc := d.sourceDocument()
+	if doc == nil {
+		return "ltr"
+	}
+
+	for _, selector := range []string{"html", "body"} {
+		if dir, ok := doc.Find(selector).First().Attr("dir"); ok {
+			if dir = strings.ToLower(strings.TrimSpace(dir)); dir == "rtl" || dir == "ltr" {
+				return dir
+			}
+		}
+	}
+
+	rtl, ltr := 0, 0
+	for _, r := range d.RawBodyText() {
+		switch {
+		case unicode.In(r, unicode.Arabic, unicode.Hebrew, unicode.Syriac, unicode.Thaana, unicode.Nko):
+			rtl++
+		case unicode.IsLetter(r):
+			ltr++
+		}
+	}
+
+	if rtl > ltr {
+		return "rtl"
+	}
+
+	return "ltr"
+}
+
+// rtlOutput marks the root of the sanitized content as right to left, so it
+// renders correctly outside of the original page.
+func rtlOutput(content string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		Logger.Println("Unable to create document", err)
+		return content
+	}
+
+	root := doc.Find("body").Children().First()
+	if root.Length() == 0 {
+		return content
+	}
+	root.SetAttr("dir", "rtl")
+
+	text, _ := doc.Html()
+	return text
+}
+
+// xhtmlOutput makes the sanitized content well-formed XHTML for XML based
+// pipelines such as EPUB: the document is put in the XHTML namespace and
+// characters XML does not allow are dropped. Void elements such as <br/> are
+// already self-closed by the renderer.
+func xhtmlOutput(content string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		Logger.Println("Unable to create document", err)
+		return content
+	}
+
+	doc.Find("html").SetAttr("xmlns", "http://www.w3.org/1999/xhtml")
+
+	var clean func(n *html.Node)
+	clean = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			n.Data = strings.Map(xmlChar, n.Data)
+		}
+
+		for i := range n.Attr {
+			n.Attr[i].Val = strings.Map(xmlChar, n.Attr[i].Val)
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			clean(c)
+		}
+	}
+	clean(doc.Get(0))
+
+	text, _ := doc.Html()
+	return text
+}
+
+// xmlChar drops r, by returning -1, if it is not allowed in XML 1.0.
+func xmlChar(r rune) rune {
+	switch {
+	case r == '\t' || r == '\n' || r == '\r':
+		return r
+	case r >= 0x20 && r <= 0xD7FF, r >= 0xE000 && r <= 0xFFFD, r >= 0x10000 && r <= 0x10FFFF:
+		return r
+	}
+
+	return -1
+}
+
+// ContentDocument returns the extracted content as a new goquery document, for
+// further processing. It is independent of d, so it can be changed freely.
+func (d *Document) ContentDocument() (*goquery.Document, error) {
+	return goquery.NewDocumentFromReader(strings.NewReader(d.Content()))
+}
+
+// CleanHTML returns the extracted content as a minimal, self-contained HTML
+// document with the title in its head, suitable for e-readers.
+func (d *Document) CleanHTML() string {
+	doc, err := d.ContentDocument()
+	if err != nil {
+		Logger.Println("Unable to create document", err)
+		return ""
+	}
+
+	body, _ := doc.Find("body").First().Html()
+
+	output := bytes.NewBufferString("<!DOCTYPE html>\n")
+	fmt.Fprintf(output, "<html><head><meta charset=\"utf-8\"><title>%s</title></head>", html.EscapeString(d.Title()))
+	fmt.Fprintf(output, "<body>%s</body></html>", body)
+
+	return output.String()
+}
+
+// ContentWithParagraphIDs returns the extracted content with each paragraph
+// numbered in order in a data-p attribute, starting from 0, so annotations can
+// refer to them. The numbers are stable for as long as the extraction is.
+func (d *Document) ContentWithParagraphIDs() string {
+	content := d.Content()
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		Logger.Println("Unable to create document", err)
+		return content
+	}
+
+	doc.Find("p").Each(func(i int, p *goquery.Selection) {
+		p.SetAttr("data-p", strconv.Itoa(i))
+	})
+
+	text, _ := doc.Html()
+	return text
+}
+
+// RSSItem returns the article as an RSS <item>, for building feeds from
+// extracted pages. The link is the page's canonical URL, the description its
+// meta description or, failing that, the first paragraph, and the content is
+// the article's HTML as content:encoded. The feed should declare the content
+// namespace, http://purl.org/rss/1.0/modules/content/.
+func (d *Document) RSSItem() string {
+	body := ""
+	if doc, err := goquery.NewDocumentFromReader(strings.NewReader(d.Content())); err == nil {
+		body, _ = doc.Find("body").First().Html()
+	} else {
+		Logger.Println("Unable to create document", err)
+	}
+
+	metadata := d.Metadata()
+
+	link := ""
+	if doc := d.sourceDocument(); doc != nil {
+		if href, ok := doc.Find(`link[rel~="canonical"]`).First().Attr("href"); ok && strings.TrimSpace(href) != "" {
+			link = d.resolveURL(href)
+		}
+	}
+	if link == "" {
+		link = metadata.URL
+	}
+	if link == "" && d.BaseURL != nil {
+		link = d.BaseURL.String()
+	}
+
+	description := metadata.Description
+	if description == "" {
+		d.EachTextBlock(func(tag string, text string) {
+			if description == "" {
+				description = text
+			}
+		})
+	}
+
+	output := bytes.NewBufferString("<item>")
+
+	element := func(name, value string) {
+		if value == "" {
+			return
+		}
+
+		fmt.Fprintf(output, "<%s>", name)
+		xml.EscapeText(output, []byte(value))
+		fmt.Fprintf(output, "</%s>", name)
+	}
+
+	element("title", d.Title())
+	element("link", link)
+	element("guid", link)
+	element("description", description)
+
+	if published, _ := d.PublishedDate(); !published.IsZero() {
+		element("pubDate", published.Format(time.RFC1123Z))
+	}
+
+	// CDATA sections can't contain ]]>, so split it across two sections
+	fmt.Fprintf(output, "<content:encoded><![CDATA[%s]]></content:encoded>", strings.Replace(body, "]]>", "]]]]><![CDATA[>", -1))
+	output.WriteString("</item>")
+
+	return output.String()
+}
+
+// punctuationReplacer replaces typographic punctuation with its closest ASCII
+// equivalent.
+var punctuationReplacer = strings.NewReplacer(
+	"\u2018", "'", "\u2019", "'", "\u201a", "'", "\u201b", "'",
+	"\u201c", `"`, "\u201d", `"`, "\u201e", `"`, "\u201f", `"`,
+	"\u2013", "-", "\u2014", "--",
+	"\u2026", "...",
+)
+
+// Text returns the extracted content as plain text, with paragraphs separated
+// by blank lines. When WrapWidth is set, paragraphs are wrapped at that many
+// characters on word boundaries. When NormalizePunctuation is set, curly
+// quotes, dashes and ellipses are replaced with ASCII.
+func (d *Document) Text() string {
+	paragraphs := make([]string, 0)
+	d.EachTextBlock(func(tag string, text string) {
+		if d.NormalizePunctuation {
+			text = punctuationReplacer.Replace(text)
+		}
+
+		paragraphs = append(paragraphs, wrapText(text, d.WrapWidth))
+	})
+
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// wordsPerMinute is the average adult reading speed used by ReadingTime.
+const wordsPerMinute = 200
+
+// WordCount returns the number of words in the extracted text.
+func (d *Document) WordCount() int {
+	return len(strings.Fields(d.Text()))
+}
+
+// ReadingTime estimates how long the extracted text takes to read, at 200
+// words per minute.
+func (d *Document) ReadingTime() time.Duration {
+	return time.Duration(d.WordCount()) * time.Minute / wordsPerMinute
+}
+
+// RawBodyText returns all of the text in the page's <body>, whitespace
+// normalized, without any extraction. Only the contents of NonContentTags,
+// such as scripts, are left out. Comparing its length with that of Text gives
+// the fraction of the page that is article.
+func (d *Document) RawBodyText() string {
+	doc := d.sourceDocument()
+	if doc == nil {
+		return ""
+	}
+
+	body := doc.Find("body").First().Clone()
+	if len(d.NonContentTags) > 0 {
+		removeNodes(body.Find(strings.Join(d.NonContentTags, ",")))
+	}
+
+	return strings.Join(strings.Fields(body.Text()), " ")
+}
+
+// Shingles returns the hashes of every run of k consecutive words in the
+// extracted text, without duplicates, in the order they first appear. Words
+// are compared ignoring case and punctuation, so lightly edited copies of an
+// article share most of their shingles and Jaccard can be used to find
+// syndicated or near-duplicate content.
+func (d *Document) Shingles(k int) []uint64 {
+	if k < 1 {
+		return nil
+	}
+
+	words := strings.FieldsFunc(d.Text(), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return nil
+	}
+
+	if k > len(words) {
+		k = len(words)
+	}
+
+	seen := make(map[uint64]bool)
+	shingles := make([]uint64, 0, len(words)-k+1)
+	for i := 0; i+k <= len(words); i++ {
+		fingerprint, _ := textFingerprint(strings.Join(words[i:i+k], " "))
+		if !seen[fingerprint] {
+			seen[fingerprint] = true
+			shingles = append(shingles, fingerprint)
+		}
+	}
+
+	return shingles
+}
+
+// Jaccard returns the fraction of shingles that a and b have in common, from
+// 0 when they share none to 1 when they are the same.
+func Jaccard(a, b []uint64) float64 {
+	set := make(map[uint64]bool, len(a))
+	for _, shingle := range a {
+		set[shingle] = true
+	}
+
+	union := len(set)
+	shared := 0
+	for _, shingle := range b {
+		if set[shingle] {
+			shared++
+			delete(set, shingle)
+		} else {
+			union++
+		}
+	}
+
+	if union == 0 {
+		return 0
+	}
+
+	return float64(shared) / float64(union)
+}
+
+// EachTextBlock calls fn, in order, with the tag name and whitespace
+// normalized text of each block level chunk of text in the extracted content.
+func (d *Document) EachTextBlock(fn func(tag string, text string)) {
+	doc, err := d.ContentDocument()
+	if err != nil {
+		Logger.Println("Unable to create document", err)
+		return
+	}
+
+	for _, block := range d.textBlocks(doc.Find("body").First().Get(0)) {
+		fn(block.tag, block.text)
+	}
+}
+
+// Section is a part of the article under one of its own headings.
+type Section struct {
+	// Heading is the text of the heading, empty for any introduction before
+	// the first heading.
+	Heading string
+	// Level is 1 to 6 for <h1> to <h6>, and 0 for the introduction.
+	Level int
+	// HTML is the content following the heading, up to the next one.
+	HTML string
+	// Text is the content as plain text, with paragraphs separated by blank
+	// lines.
+	Text string
+}
+
+var headingTags = map[string]int{"h1": 1, "h2": 2, "h3": 3, "h4": 4, "h5": 5, "h6": 6}
+
+// Sections splits the extracted content at its headings, for building a table
+// of contents. Headings are kept for this even if they are not in
+// WhitelistTags. Content before the first heading is returned as an untitled
+// introduction, if there is any.
+func (d *Document) Sections() []Section {
+	content, err := d.ContentWithOptions(func(c *Document) {
+		c.WhitelistTags = append(c.WhitelistTags, "h1", "h2", "h3", "h4", "h5", "h6")
+	})
+	if err != nil {
+		Logger.Println("Unable to extract content", err)
+		return nil
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		Logger.Println("Unable to create document", err)
+		return nil
+	}
+
+	sections := []Section{{}}
+	var parts, texts []string
+
+	flush := func() {
+		current := &sections[len(sections)-1]
+		current.HTML = strings.TrimSpace(strings.Join(parts, ""))
+		current.Text = strings.Join(texts, "\n\n")
+		parts, texts = nil, nil
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if level, ok := headingTags[c.Data]; ok && c.Type == html.ElementNode {
+				flush()
+				heading := strings.Join(strings.Fields(goquery.NewDocumentFromNode(c).Text()), " ")
+				sections = append(sections, Section{Heading: heading, Level: level})
+				continue
+			}
+
+			// look for headings within wrappers, keeping everything else whole
+			if c.Type == html.ElementNode && goquery.NewDocumentFromNode(c).Find("h1,h2,h3,h4,h5,h6").Length() > 0 {
+				walk(c)
+				continue
+			}
+
+			var buf bytes.Buffer
+			html.Render(&buf, c)
+			parts = append(parts, buf.String())
+
+			if c.Type == html.TextNode {
+				if t := collapseSpaces(c.Data, d.PreserveNonBreakingSpaces); t != "" {
+					texts = append(texts, t)
+				}
+			} else {
+				for _, block := range d.textBlocks(c) {
+					texts = append(texts, block.text)
+				}
+			}
+		}
+	}
+	walk(doc.Find("body").Get(0))
+	flush()
+
+	if intro := sections[0]; intro.HTML == "" {
+		sections = sections[1:]
+	}
+
+	return sections
+}
+
+// textBlock is a chunk of text from a single block level element.
+type textBlock struct {
+	tag  string
+	text string
+}
+
+var blockTags = map[string]bool{
+	"address":    true,
+	"blockquote": true,
+	"br":         true,
+	"dd":         true,
+	"div":        true,
+	"dl":         true,
+	"dt":         true,
+	"h1":         true,
+	"h2":         true,
+	"h3":         true,
+	"h4":         true,
+	"h5":         true,
+	"h6":         true,
+	"hr":         true,
+	"li":         true,
+	"ol":         true,
+	"p":          true,
+	"pre":        true,
+	"table":      true,
+	"td":         true,
+	"th":         true,
+	"tr":         true,
+	"ul":         true,
+}
+
+// isLeafBlock returns whether n holds no block level elements, other than
+// line breaks and rules.
+func isLeafBlock(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+
+		if (blockTags[c.Data] && c.Data != "br" && c.Data != "hr") || !isLeafBlock(c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rawTextTags hold scripts, styles and markup rather than text.
+var rawTextTags = map[string]bool{
+	"noscript": true,
+	"script":   true,
+	"style":    true,
+	"template": true,
+}
+
+// textBlocks splits the text within n into blocks at block level elements,
+// collapsing whitespace and dropping empty blocks. Non-breaking spaces are
+// treated as any other whitespace unless PreserveNonBreakingSpaces is set,
+// and with PreserveHorizontalRules each <hr> is a block of its own, "---".
+func (d *Document) textBlocks(n *html.Node) []textBlock {
+	blocks := make([]textBlock, 0)
+	if n == nil {
+		return blocks
+	}
+
+	var text bytes.Buffer
+	tag := n.Data
+
+	flush := func() {
+		if t := collapseSpaces(text.String(), d.PreserveNonBreakingSpaces); t != "" {
+			blocks = append(blocks, textBlock{tag, t})
+		}
+		text.Reset()
+	}
+
+	var walk func(n *html.Node, parent string)
+	walk = func(n *html.Node, parent string) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch c.Type {
+			case html.TextNode:
+				text.WriteString(c.Data)
+			case html.ElementNode:
+				if rawTextTags[c.Data] {
+					continue
+				}
+
+				if c.Data == "hr" && d.PreserveHorizontalRules {
+					flush()
+					blocks = append(blocks, textBlock{"hr", "---"})
+				} else if blockTags[c.Data] {
+					flush()
+					tag = c.Data
+					walk(c, c.Data)
+					flush()
+					tag = parent
+				} else {
+					walk(c, parent)
+				}
+			}
+		}
+	}
+
+	walk(n, tag)
+	flush()
+
+	return blocks
+}
+
+// collapseSpaces trims s and collapses each run of whitespace within it to a
+// single space. If keepNBSP is set, a lone non-breaking space is left as it
+// is rather than being treated as whitespace.
+func collapseSpaces(s string, keepNBSP bool) string {
+	if !keepNBSP {
+		return strings.Join(strings.Fields(s), " ")
+	}
+
+	s = spaceRunRegexp.ReplaceAllString(s, " ")
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) && r != '\u00a0'
+	}), " ")
+}
+
+// wrapText wraps s at width runes on word boundaries. Words longer than width
+// are left on a line of their own.
+func wrapText(s string, width int) string {
+	if width <= 0 {
+		return s
+	}
+
+	var output bytes.Buffer
+	lineLength := 0
+
+	for _, word := range strings.Fields(s) {
+		wordLength := utf8.RuneCountInString(word)
+
+		if lineLength > 0 && lineLength+1+wordLength > width {
+			output.WriteByte('\n')
+			lineLength = 0
+		} else if lineLength > 0 {
+			output.WriteByte(' ')
+			lineLength++
+		}
+
+		output.WriteString(word)
+		lineLength += wordLength
+	}
+
+	return output.String()
+}
+
+// TextMetrics are readability statistics of the extracted text.
+type TextMetrics struct {
+	Sentences int
+	Words     int
+	Syllables int
+
+	// FleschReadingEase is roughly 0 to 100, higher being easier to read.
+	FleschReadingEase float64
+	// FleschKincaidGrade is the US school grade needed to follow the text.
+	FleschKincaidGrade float64
+}
+
+// ReadabilityMetrics returns the Flesch reading ease and Flesch-Kincaid grade
+// level of the extracted text. Sentences are counted by their terminating
+// punctuation, with each block of text counting as at least one sentence.
+// Syllables are estimated, as described for countSyllables.
+func (d *Document) ReadabilityMetrics() TextMetrics {
+	metrics := TextMetrics{}
+
+	d.EachTextBlock(func(tag string, text string) {
+		words := strings.FieldsFunc(text, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\'' && r != '’'
+		})
+
+		count := 0
+		for _, word := range words {
+			if strings.IndexFunc(word, unicode.IsLetter) < 0 {
+				continue
+			}
+
+			count++
+			metrics.Syllables += countSyllables(word)
+		}
+
+		if count == 0 {
+			return
+		}
+
+		metrics.Words += count
+
+		sentences := len(sentenceEndRegexp.FindAllString(text, -1))
+		if sentences == 0 {
+			sentences = 1
+		}
+		metrics.Sentences += sentences
+	})
+
+	if metrics.Words == 0 {
+		return metrics
+	}
+
+	wordsPerSentence := float64(metrics.Words) / float64(metrics.Sentences)
+	syllablesPerWord := float64(metrics.Syllables) / float64(metrics.Words)
+
+	metrics.FleschReadingEase = 206.835 - 1.015*wordsPerSentence - 84.6*syllablesPerWord
+	metrics.FleschKincaidGrade = 0.39*wordsPerSentence + 11.8*syllablesPerWord - 15.59
+
+	return metrics
+}
+
+// countSyllables estimates the syllables in an English word by counting the
+// groups of consecutive vowels, taking y as a vowel. A trailing e is taken to
+// be silent, except in endings like -ble, and every word has at least one
+// syllable. It is wrong for plenty of words, but close enough on average.
+func countSyllables(word string) int {
+	word = strings.ToLower(word)
+
+	isVowel := func(r rune) bool {
+		return strings.ContainsRune("aeiouy", r)
+	}
+
+	runes := []rune(word)
+	count := 0
+	previous := false
+	for _, r := range runes {
+		vowel := isVowel(r)
+		if vowel && !previous {
+			count++
+		}
+		previous = vowel
+	}
+
+	if n := len(runes); count > 1 && n > 2 && runes[n-1] == 'e' && !isVowel(runes[n-2]) {
+		if !(runes[n-2] == 'l' && !isVowel(runes[n-3])) {
+			count--
+		}
+	}
+
+	if count < 1 {
+		count = 1
+	}
+
+	return count
+}
+
+// BoilerplateFilter learns the blocks of text that repeat across the pages of
+// a site, such as navigation and footers, so they can be removed from other
+// pages with Document.RemoveBoilerplate. It is safe for concurrent use. The
+// zero value is ready to use once MinOccurrences is set.
+type BoilerplateFilter struct {
+	// MinOccurrences is the number of documents a block must appear in to be
+	// considered boilerplate.
+	MinOccurrences int
+
+	mu     sync.Mutex
+	counts map[uint64]int
+}
+
+const boilerplateSelector = "p,li,td,th,dd,dt,h1,h2,h3,h4,h5,h6,blockquote,address,div"
+
+// NewBoilerplateFilter returns a filter treating blocks seen in two or more
+// documents as boilerplate.
+func NewBoilerplateFilter() *BoilerplateFilter {
+	return &BoilerplateFilter{
+		MinOccurrences: 2,
+		counts:         make(map[uint64]int),
+	}
+}
+
+// Add records the blocks of text in d's page. Each block is counted at most
+// once per document.
+func (f *BoilerplateFilter) Add(d *Document) {
+	doc := d.sourceDocument()
+	if doc == nil {
+		return
+	}
+
+	seen := make(map[uint64]bool)
+	doc.Find("body").First().Find(boilerplateSelector).Each(func(i int, s *goquery.Selection) {
+		if fingerprint, ok := textFingerprint(s.Text()); ok {
+			seen[fingerprint] = true
+		}
+	})
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.counts == nil {
+		f.counts = make(map[uint64]int)
+	}
+
+	for fingerprint := range seen {
+		f.counts[fingerprint]++
+	}
+}
+
+func (f *BoilerplateFilter) isBoilerplate(text string) bool {
+	fingerprint, ok := textFingerprint(text)
+	if !ok {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.counts[fingerprint] >= f.MinOccurrences
+}
+
+// textFingerprint hashes text ignoring case and whitespace. Blocks without any
+// text have no fingerprint.
+func textFingerprint(text string) (uint64, bool) {
+	text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+	if text == "" {
+		return 0, false
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(text))
+
+	return h.Sum64(), true
+}
+
+// RemoveBoilerplate removes blocks that f has learnt are repeated across the
+// site during extraction. It must be called before the content is extracted.
+func (d *Document) RemoveBoilerplate(f *BoilerplateFilter) {
+	d.boilerplate = f
+}
+
+// Result is the outcome of extracting a single document with ExtractMany.
+type Result struct {
+	Title       string
+	Content     string
+	Text        string
+	WordCount   int
+	ReadingTime time.Duration
+}
+
+// ExtractMany extracts the content of each input using a pool of concurrency
+// workers. Each document is configured by opts, as with NewDocument. Results
+// are returned in the same order as inputs. Inputs that fail, or that weren't
+// reached before ctx was done, have a nil result and their errors are joined
+// together in the returned error, which errors.Is matches against each of them.
+func ExtractMany(ctx context.Context, inputs []string, concurrency int, opts ...Option) ([]*Result, error) {
+	return ExtractManyWithProgress(ctx, inputs, concurrency, nil, opts...)
+}
+
+// ExtractManyWithProgress is like ExtractMany but calls progress with the
+// index of each input and its error as soon as it has been extracted. Calls to
+// progress are never made concurrently, but they are made in the order the
+// inputs finish rather than the order they were given.
+func ExtractManyWithProgress(ctx context.Context, inputs []string, concurrency int, progress func(i int, err error), opts ...Option) ([]*Result, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]*Result, len(inputs))
+	errs := make([]error, len(inputs))
+	indexes := make(chan int)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i], errs[i] = extract(ctx, inputs[i], opts)
+				if progress != nil {
+					mu.Lock()
+					progress(i, errs[i])
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for i := range inputs {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			for ; i < len(inputs); i++ {
+				errs[i] = ctx.Err()
+			}
+			break dispatch
+		}
+	}
+
+	close(indexes)
+	wg.Wait()
+
+	failures := make(joinedErrors, 0)
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, fmt.Errorf("input %d: %w", i, err))
+		}
+	}
+
+	if len(failures) == 0 {
+		return results, nil
+	}
+
+	return results, failures
+}
+
+// joinedErrors is a list of errors reported as one, each on its own line.
+type joinedErrors []error
+
+func (e joinedErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// Is reports whether any of the errors matches target.
+func (e joinedErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func extract(ctx context.Context, input string, opts []Option) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	doc, err := NewDocument(input, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	content := doc.Content()
+	return &Result{
+		Title:       doc.Title(),
+		Content:     content,
+		Text:        doc.Text(),
+		WordCount:   doc.WordCount(),
+		ReadingTime: doc.ReadingTime(),
+	}, nil
+}
+
+// ContentWithOptions extracts the content using a copy of the document with
+// opts applied, leaving the configuration of d untouched.
+func (d *Document) ContentWithOptions(opts ...Option) (string, error) {
+	c := d.withOptions(opts...)
+
+	// extraction is destructive, so the copy needs its own tree
+	if err := c.initializeHtml(c.input); err != nil {
+		return "", err
+	}
+
+	return c.Content(), nil
+}
+
+// withOptions returns a copy of d with opts applied, sharing none of its
+// configuration or extraction state. It has no document until one is parsed.
+func (d *Document) withOptions(opts ...Option) *Document {
+	c := *d
+	c.content = ""
+	c.title = ""
+	c.candidates = nil
+	c.bestCandidate = nil
+	c.semantic = false
+	c.articleNodes = nil
+	c.WhitelistTags = append([]string(nil), d.WhitelistTags...)
+	c.NonContentTags = append([]string(nil), d.NonContentTags...)
+	c.CustomBlockTags = append([]string(nil), d.CustomBlockTags...)
+	c.JunkPhrases = append([]string(nil), d.JunkPhrases...)
+	c.TrackingParams = append([]string(nil), d.TrackingParams...)
+	c.RetryStrategy = append([]RetryStep(nil), d.RetryStrategy...)
+
+	c.TagScores = make(map[string]int, len(d.TagScores))
+	for tag, score := range d.TagScores {
+		c.TagScores[tag] = score
+	}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return &c
+}
+
+// ArticleNodes returns, in order, the nodes that were selected into the
+// article. They point into the live document tree before sanitization, so
+// attributes are intact but the tree has already had unlikely candidates and
+// non-content tags removed. Callers should not modify them.
+func (d *Document) ArticleNodes() []*html.Node {
+	if d.content == "" {
+		d.Content()
+	}
+
+	return d.articleNodes
+}
+
+func (d *Document) Title() string {
+	if d.title == "" {
+		d.title = strings.TrimSpace(d.document.Find("title").First().Text())
+
+		// titles are often the headline followed by the site's name, in
+		// which case the headline on its own is cleaner
+		if d.title != "" {
+			if headline := d.titlePrefixHeading(d.title); headline != "" {
+				d.title = headline
+			}
+		}
+	}
+
+	// fragments and some pages have no usable <title>, so fall back to the
+	// leading heading of the article
+	if d.title == "" {
+		if d.bestCandidate == nil {
+			d.Content()
+		}
+
+		d.title = d.headingTitle()
+	}
+
+	return d.title
+}
+
+// titlePrefixHeading returns the text of the first <h1> in the page that the
+// title starts with, followed by something other than a letter or digit, as in
+// "Headline - Site". An empty string is returned if there's no such <h1>.
+func (d *Document) titlePrefixHeading(title string) string {
+	doc := d.sourceDocument()
+	if doc == nil {
+		return ""
+	}
+
+	headline := ""
+	doc.Find("body h1").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		text := strings.Join(strings.Fields(s.Text()), " ")
+		if text == "" || len(text) >= len(title) || !strings.HasPrefix(title, text) {
+			return true
+		}
+
+		if r, _ := utf8.DecodeRuneInString(title[len(text):]); !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			headline = text
+			return false
+		}
+
+		return true
+	})
+
+	return headline
+}
+
+// headingTitle returns the text of the <h1> or <h2> within the best candidate
+// with the highest class weight, preferring the heading closest to the start
+// of the article.
+func (d *Document) headingTitle() string {
+	title := ""
+	bestWeight := math.MinInt32
+
+	d.bestCandidate.selection.Find("h1,h2").Each(func(i int, s *goquery.Selection) {
+		text := strings.TrimSpace(s.Text())
+		if text == "" {
+			return
+		}
+
+		weight := d.classWeight(s)
+		if s.Is("h1") {
+			weight++
+		}
+
+		if weight > bestWeight {
+			title = text
+			bestWeight = weight
+		}
+	})
+
+	return title
+}
+
+// HasPaywall reports whether the document carries common signals of a paywall,
+// meaning the extracted content is likely only a partial article.
+func (d *Document) HasPaywall() bool {
+	doc := d.sourceDocument()
+	if doc == nil {
+		return false
+	}
+
+	paywall := false
+
+	doc.Find(`meta[name="robots"]`).EachWithBreak(func(i int, s *goquery.Selection) bool {
+		content, _ := s.Attr("content")
+		paywall = hasSnippetLimit(content)
+		return !paywall
+	})
+
+	if !paywall {
+		doc.Find("[class],[id]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+			class, _ := s.Attr("class")
+			id, _ := s.Attr("id")
+			paywall = paywallRegexp.MatchString(class + " " + id)
+			return !paywall
+		})
+	}
+
+	if !paywall {
+		for _, data := range d.jsonLD() {
+			if isNotAccessibleForFree(data) {
+				return true
+			}
+		}
+	}
+
+	return paywall
+}
+
+// hasSnippetLimit reports whether the robots directives in content limit
+// snippets to a number of characters. max-snippet:-1 means there is no limit.
+func hasSnippetLimit(content string) bool {
+	for _, directive := range strings.Split(content, ",") {
+		parts := strings.SplitN(directive, ":", 2)
+		if len(parts) != 2 || strings.ToLower(strings.TrimSpace(parts[0])) != "max-snippet" {
+			continue
+		}
+
+		if limit, err := strconv.Atoi(strings.TrimSpace(parts[1])); err == nil && limit >= 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Section returns the section or category the article was published in,
+// taken from the article:section meta tag or the page breadcrumbs.
+func (d *Document) Section() string {
+	doc := d.sourceDocument()
+	if doc == nil {
+		return ""
+	}
+
+	section, _ := doc.Find(`meta[property="article:section"]`).First().Attr("content")
+	section = strings.TrimSpace(section)
+
+	// the last linked breadcrumb is the closest section to the article
+	if section == "" {
+		breadcrumbs := doc.Find(`[itemtype*="BreadcrumbList"],nav.breadcrumb,nav.breadcrumbs`).First()
+		section = strings.TrimSpace(breadcrumbs.Find("a").Last().Text())
+	}
+
+	return section
+}
+
+// ImageCredits returns the photo credits found within the image captions on
+// the page.
+func (d *Document) ImageCredits() []string {
+	credits := make([]string, 0)
+
+	doc := d.sourceDocument()
+	if doc == nil {
+		return credits
+	}
+
+	doc.Find(captionSelector).Find(imageCreditSelector).Each(func(i int, s *goquery.Selection) {
+		if credit := strings.TrimSpace(s.Text()); credit != "" {
+			credits = append(credits, credit)
+		}
+	})
+
+	return credits
+}
+
+// PullQuotes returns the text of the pull quotes on the page, the sentences
+// that magazine layouts repeat in large type. They are left out of the
+// content, as they would duplicate the article's own text.
+func (d *Document) PullQuotes() []string {
+	quotes := make([]string, 0)
+
+	doc := d.sourceDocument()
+	if doc == nil {
+		return quotes
+	}
+
+	doc.Find(pullQuoteSelector).Each(func(i int, s *goquery.Selection) {
+		// a pull quote within another, such as a <p> in an <aside>, is
+		// already included
+		if s.ParentsFiltered(pullQuoteSelector).Length() > 0 {
+			return
+		}
+
+		if quote := strings.Join(strings.Fields(s.Text()), " "); quote != "" {
+			quotes = append(quotes, quote)
+		}
+	})
+
+	return quotes
+}
+
+// Subtitle returns the article's deck, the sentence or two below the headline
+// that sums it up. An empty string is returned if the page doesn't mark one.
+func (d *Document) Subtitle() string {
+	doc := d.sourceDocument()
+	if doc == nil {
+		return ""
+	}
+
+	var subtitle string
+	doc.Find(subtitleSelector).EachWithBreak(func(i int, s *goquery.Selection) bool {
+		text := s.Text()
+		if s.Is("meta") {
+			text, _ = s.Attr("content")
+		}
+
+		subtitle = strings.Join(strings.Fields(text), " ")
+		return subtitle == ""
+	})
+
+	return subtitle
+}
+
+// Metadata returns the OpenGraph and Twitter card metadata of the page,
+// preferring OpenGraph when both are present. Image and URL are resolved
+// against BaseURL.
+func (d *Document) Metadata() Metadata {
+	metadata := Metadata{}
+
+	doc := d.sourceDocument()
+	if doc == nil {
+		return metadata
+	}
+
+	meta := func(names ...string) string {
+		return metaContent(doc, names...)
+	}
+
+	metadata.Title = meta("og:title", "twitter:title")
+	metadata.Description = meta("og:description", "twitter:description")
+	metadata.Image = meta("og:image", "og:image:url", "twitter:image", "twitter:image:src")
+	metadata.URL = meta("og:url", "twitter:url")
+	// twitter:site is the site's @handle rather than its name
+	metadata.SiteName = meta("og:site_name")
+	metadata.Type = meta("og:type")
+	metadata.Card = meta("twitter:card")
+	metadata.Creator = meta("twitter:creator")
+
+	if metadata.Image != "" {
+		metadata.Image = d.resolveURL(metadata.Image)
+	}
+	if metadata.URL != "" {
+		metadata.URL = d.resolveURL(metadata.URL)
+	}
+
+	return metadata
+}
+
+// Description returns the page's own summary, as written by its author, from
+// its og:description, description or twitter:description meta tags. It is
+// never derived from the text of the article. An empty string is returned if
+// the page has no description.
+func (d *Document) Description() string {
+	doc := d.sourceDocument()
+	if doc == nil {
+		return ""
+	}
+
+	return metaContent(doc, "og:description", "description", "twitter:description")
+}
+
+// metaContent returns the content of the first of the named meta tags that
+// is present and not empty.
+func metaContent(doc *goquery.Document, names ...string) string {
+	for _, name := range names {
+		// twitter tags are supposed to use name, but property is common
+		selector := fmt.Sprintf(`meta[property="%s"],meta[name="%s"]`, name, name)
+		if content, ok := doc.Find(selector).First().Attr("content"); ok && strings.TrimSpace(content) != "" {
+			return strings.TrimSpace(content)
+		}
+	}
+
+	return ""
+}
+
+// TopVideo returns the URL of the article's main video, resolved against
+// BaseURL. It is taken from the page's og:video or twitter:player tags, or
+// failing that the first video or known player embedded in the article. An
+// empty string is returned if there isn't one.
+func (d *Document) TopVideo() string {
+	doc := d.sourceDocument()
+	if doc == nil {
+		return ""
+	}
+
+	if video := metaContent(doc, "og:video:secure_url", "og:video:url", "og:video", "twitter:player"); video != "" {
+		return d.resolveURL(video)
+	}
+
+	d.Content()
+	if d.bestCandidate == nil {
+		return ""
+	}
+
+	src := ""
+	d.bestCandidate.selection.Find("video[src],video source[src],iframe[src],embed[src]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		src, _ = s.Attr("src")
+		if !s.Is("video,source") && !videoEmbedRegexp.MatchString(src) {
+			src = ""
+		}
+
+		return src == ""
+	})
+
+	if src == "" {
+		return ""
+	}
+
+	return d.resolveURL(src)
+}
+
+// MediaCounts returns the number of images, videos and other embeds, such as
+// maps or embedded tweets, in the article. They are counted in the selected
+// nodes before sanitizing, so they don't depend on WhitelistTags. Images too
+// small to keep with MinImageWidth and MinImageHeight are not counted, and
+// videos include those embedded from known players.
+func (d *Document) MediaCounts() (images, videos, embeds int) {
+	for _, n := range d.ArticleNodes() {
+		article := goquery.NewDocumentFromNode(n).Selection
+
+		article.Find("img").AddSelection(article.Filter("img")).Each(func(i int, img *goquery.Selection) {
+			width, hasWidth := imageDimension(img, "width")
+			height, hasHeight := imageDimension(img, "height")
+
+			if (!hasWidth || width >= d.MinImageWidth) && (!hasHeight || height >= d.MinImageHeight) {
+				images++
+			}
+		})
+
+		article.Find("video,iframe,embed,object,[data-embed]").AddSelection(article.Filter("video,iframe,embed,object,[data-embed]")).Each(func(i int, s *goquery.Selection) {
+			// the <embed> within an <object> is its fallback
+			if s.Is("embed") && s.ParentsFiltered("object").Length() > 0 {
+				return
+			}
+
+			src, _ := s.Attr("src")
+			if s.Is("video") || videoEmbedRegexp.MatchString(src) {
+				videos++
+			} else {
+				embeds++
+			}
+		})
+	}
+
+	return images, videos, embeds
+}
+
+// PageType guesses whether the page is a single "article", a "listing" of
+// links to articles such as a home or section page, or something "other". It
+// is based on the page's metadata, the link density of the page and whether
+// the best candidate stands out or is one of a set of similar blocks.
+func (d *Document) PageType() string {
+	doc := d.sourceDocument()
+	if doc == nil {
+		return "other"
+	}
+
+	schema := metaContent(doc, "og:type") == "article"
+	for _, data := range d.jsonLD() {
+		for _, t := range []string{"Article", "NewsArticle", "BlogPosting", "Report", "ScholarlyArticle"} {
+			if findJSONLDType(data, t) != nil {
+				schema = true
+			}
+		}
+	}
+
+	body := doc.Find("body").First().Clone()
+	if len(d.NonContentTags) > 0 {
+		removeNodes(body.Find(strings.Join(d.NonContentTags, ",")))
+	}
+
+	content := d.Content()
+	if d.bestCandidate == nil {
+		return "other"
+	}
+
+	if schema || d.semantic {
+		return "article"
+	}
+
+	if d.getLinkDensity(body) > .5 || d.similarBlocks() >= 3 {
+		return "listing"
+	}
+
+	if len(strings.TrimSpace(content)) >= d.RetryLength {
+		return "article"
+	}
+
+	return "other"
+}
+
+// similarBlocks returns the size of the largest set of similar blocks around
+// the best candidate. That is either the best candidate and its siblings that
+// score at least half as well, or the best candidate's children when none of
+// them dominates the others, as in a list of teasers.
+func (d *Document) similarBlocks() int {
+	best := d.bestCandidate
+	parent := best.Node().Parent
+
+	siblings := 1
+	children, total, max := 0, float32(0), float32(0)
+
+	for n, c := range d.candidates {
+		if n.Parent == parent && n != best.Node() && c.score >= best.score*.5 {
+			siblings++
+		}
+
+		if n.Parent == best.Node() {
+			children++
+			total += c.score
+			if c.score > max {
+				max = c.score
+			}
+		}
+	}
+
+	if children >= 3 && max <= total/2 && children > siblings {
+		return children
+	}
+
+	return siblings
+}
+
+// PublishedDate returns when the article was published, taken from the
+// page's meta tags, microdata or JSON-LD. Pages created with
+// NewDocumentFromURL that have no date fall back to the Last-Modified header.
+// The source of the date is returned along with it, "meta" or "header"
+// accordingly. The zero time and an empty source are returned when no date is
+// found.
+func (d *Document) PublishedDate() (time.Time, string) {
+	if doc := d.sourceDocument(); doc != nil {
+		dates := make([]string, 0)
+
+		doc.Find(`meta[property="article:published_time"],meta[itemprop="datePublished"],meta[name="date"],meta[name="pubdate"]`).Each(func(i int, s *goquery.Selection) {
+			content, _ := s.Attr("content")
+			dates = append(dates, content)
+		})
+
+		doc.Find(`time[itemprop="datePublished"][datetime],time[pubdate][datetime]`).Each(func(i int, s *goquery.Selection) {
+			datetime, _ := s.Attr("datetime")
+			dates = append(dates, datetime)
+		})
+
+		for _, data := range d.jsonLD() {
+			if article := findJSONLDKey(data, "datePublished"); article != nil {
+				dates = append(dates, jsonLDString(article["datePublished"]))
+			}
+		}
+
+		for _, date := range dates {
+			if t, ok := parseDate(date); ok {
+				return t, "meta"
+			}
+		}
+	}
+
+	if !d.lastModified.IsZero() {
+		return d.lastModified, "header"
+	}
+
+	return time.Time{}, ""
+}
+
+// parseDate parses the date formats commonly found in page metadata.
+func parseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05Z0700", "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// AlternateLanguages returns the URLs of translations of the page, keyed by
+// their hreflang code, resolved against BaseURL.
+func (d *Document) AlternateLanguages() map[string]string {
+	languages := make(map[string]string)
+
+	doc := d.sourceDocument()
+	if doc == nil {
+		return languages
+	}
+
+	doc.Find(`link[rel~="alternate"][hreflang][href]`).Each(func(i int, s *goquery.Selection) {
+		lang, _ := s.Attr("hreflang")
+		href, _ := s.Attr("href")
+
+		lang = strings.TrimSpace(lang)
+		if lang == "" || strings.TrimSpace(href) == "" {
+			return
+		}
+
+		if _, ok := languages[lang]; !ok {
+			languages[lang] = d.resolveURL(href)
+		}
+	})
+
+	return languages
+}
+
+// AuthorImage returns the URL of the author's photo or avatar, resolved
+// against BaseURL, or an empty string if there isn't one.
+func (d *Document) AuthorImage() string {
+	doc := d.sourceDocument()
+	if doc == nil {
+		return ""
+	}
+
+	// article:author is usually a profile page, but some sites use the photo
+	if author, ok := doc.Find(`meta[property="article:author"]`).First().Attr("content"); ok && imageURLRegexp.MatchString(author) {
+		return d.resolveURL(author)
+	}
+
+	if src, ok := doc.Find(`a[rel~="author"] img[src]`).First().Attr("src"); ok {
+		return d.resolveURL(src)
+	}
+
+	src := ""
+	doc.Find(`[rel~="author"],[itemprop="author"],.byline,.author`).EachWithBreak(func(i int, s *goquery.Selection) bool {
+		// the avatar is either within the byline or right next to it
+		s.Parent().Find("img[src]").EachWithBreak(func(i int, img *goquery.Selection) bool {
+			class, _ := img.Attr("class")
+			id, _ := img.Attr("id")
+
+			if authorImageRegexp.MatchString(class + " " + id) {
+				src, _ = img.Attr("src")
+			}
+
+			return src == ""
+		})
+
+		return src == ""
+	})
+
+	if src == "" {
+		return ""
+	}
+
+	return d.resolveURL(src)
+}
+
+// resolveURL resolves ref against the page's <base href>, itself resolved
+// against BaseURL, or against BaseURL alone. ref is returned unchanged when
+// there is no base or it can't be parsed.
+func (d *Document) resolveURL(ref string) string {
+	ref = strings.TrimSpace(ref)
+
+	// a <base> in the page takes precedence, and may itself be relative
+	base := d.BaseURL
+	if d.baseHref != nil {
+		if base != nil {
+			base = base.ResolveReference(d.baseHref)
+		} else if d.baseHref.IsAbs() {
+			base = d.baseHref
+		}
+	}
+
+	if base == nil {
+		return ref
+	}
+
+	u, err := url.Parse(ref)
+	if err != nil {
+		Logger.Printf("Unable to parse URL %s\n", err)
+		return ref
+	}
+
+	return base.ResolveReference(u).String()
+}
+
+// Recipe returns the schema.org Recipe embedded in the page as JSON-LD or
+// microdata, or ErrRecipeNotFound if there is none.
+func (d *Document) Recipe() (*Recipe, error) {
+	for _, data := range d.jsonLD() {
+		if r := findJSONLDType(data, "Recipe"); r != nil {
+			return &Recipe{
+				Name:         jsonLDString(r["name"]),
+				Ingredients:  jsonLDStrings(r["recipeIngredient"], r["ingredients"]),
+				Instructions: jsonLDStrings(r["recipeInstructions"]),
+				PrepTime:     jsonLDString(r["prepTime"]),
+				CookTime:     jsonLDString(r["cookTime"]),
+				TotalTime:    jsonLDString(r["totalTime"]),
+				Yield:        jsonLDString(r["recipeYield"]),
+			}, nil
+		}
+	}
+
+	doc := d.sourceDocument()
+	if doc == nil {
+		return nil, ErrRecipeNotFound
+	}
+
+	s := doc.Find(`[itemtype*="schema.org/Recipe"]`).First()
+	if s.Length() == 0 {
+		return nil, ErrRecipeNotFound
+	}
+
+	recipe := &Recipe{
+		Name:        microdataString(s.Find(`[itemprop="name"]`)),
+		PrepTime:    microdataString(s.Find(`[itemprop="prepTime"]`)),
+		CookTime:    microdataString(s.Find(`[itemprop="cookTime"]`)),
+		TotalTime:   microdataString(s.Find(`[itemprop="totalTime"]`)),
+		Yield:       microdataString(s.Find(`[itemprop="recipeYield"]`)),
+		Ingredients: make([]string, 0),
+	}
+
+	s.Find(`[itemprop="recipeIngredient"],[itemprop="ingredients"]`).Each(func(i int, s *goquery.Selection) {
+		if text := microdataString(s); text != "" {
+			recipe.Ingredients = append(recipe.Ingredients, text)
+		}
+	})
+
+	// instructions are either given step by step or as a single list
+	steps := s.Find(`[itemprop="recipeInstructions"]`)
+	if steps.Length() == 1 && steps.Find("li").Length() > 0 {
+		steps = steps.Find("li")
+	}
+
+	recipe.Instructions = make([]string, 0)
+	steps.Each(func(i int, s *goquery.Selection) {
+		if text := microdataString(s); text != "" {
+			recipe.Instructions = append(recipe.Instructions, text)
+		}
+	})
+
+	return recipe, nil
+}
+
+// findJSONLDType returns the first JSON-LD object within data with the given
+// @type, looking through arrays and @graph.
+func findJSONLDType(data interface{}, t string) map[string]interface{} {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for _, typ := range jsonLDStrings(v["@type"]) {
+			if typ == t {
+				return v
+			}
+		}
+
+		return findJSONLDType(v["@graph"], t)
+	case []interface{}:
+		for _, value := range v {
+			if found := findJSONLDType(value, t); found != nil {
+				return found
+			}
+		}
+	}
+
+	return nil
+}
+
+// findJSONLDKey returns the first JSON-LD object within data that has the
+// given key.
+func findJSONLDKey(data interface{}, key string) map[string]interface{} {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		if _, ok := v[key]; ok {
+			return v
+		}
+
+		for _, value := range v {
+			if found := findJSONLDKey(value, key); found != nil {
+				return found
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if found := findJSONLDKey(value, key); found != nil {
+				return found
+			}
+		}
+	}
+
+	return nil
+}
+
+// jsonLDString returns the first string found in a JSON-LD value.
+func jsonLDString(data interface{}) string {
+	values := jsonLDStrings(data)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// jsonLDStrings flattens JSON-LD values into strings. Objects such as
+// HowToStep contribute their text or name, and sections their items.
+func jsonLDStrings(data ...interface{}) []string {
+	values := make([]string, 0)
+
+	for _, data := range data {
+		switch v := data.(type) {
+		case string:
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		case float64:
+			values = append(values, strconv.FormatFloat(v, 'f', -1, 64))
+		case []interface{}:
+			values = append(values, jsonLDStrings(v...)...)
+		case map[string]interface{}:
+			if items, ok := v["itemListElement"]; ok {
+				values = append(values, jsonLDStrings(items)...)
+			} else if text, ok := v["text"]; ok {
+				values = append(values, jsonLDStrings(text)...)
+			} else {
+				values = append(values, jsonLDStrings(v["name"])...)
+			}
+		}
+	}
+
+	return values
+}
+
+// microdataString returns the value of the first microdata property in s,
+// preferring its machine-readable content or datetime.
+func microdataString(s *goquery.Selection) string {
+	s = s.First()
+
+	for _, attr := range []string{"content", "datetime"} {
+		if value, ok := s.Attr(attr); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+
+	return strings.TrimSpace(s.Text())
+}
+
+// isNotAccessibleForFree looks through JSON-LD data for an
+// isAccessibleForFree property set to false.
+func isNotAccessibleForFree(data interface{}) bool {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if key == "isAccessibleForFree" {
+				switch free := value.(type) {
+				case bool:
+					if !free {
+						return true
+					}
+				case string:
+					if strings.EqualFold(free, "false") {
+						return true
+					}
+				}
+			} else if isNotAccessibleForFree(value) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if isNotAccessibleForFree(value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// jsonLD returns the parsed contents of the JSON-LD blocks on the page,
+// skipping any that aren't valid JSON.
+func (d *Document) jsonLD() []interface{} {
+	blocks := make([]interface{}, 0)
+
+	doc := d.sourceDocument()
+	if doc == nil {
+		return blocks
+	}
+
+	doc.Find(`script[type="application/ld+json"]`).Each(func(i int, s *goquery.Selection) {
+		var data interface{}
+		if err := json.Unmarshal([]byte(s.Text()), &data); err != nil {
+			Logger.Printf("Unable to parse JSON-LD %s\n", err)
+			return
+		}
+
+		blocks = append(blocks, data)
+	})
+
+	return blocks
+}
+
+// sourceDocument returns a parsed copy of the untouched input. Extraction
+// removes scripts and unlikely candidates from d.document, so anything reading
+// metadata from the page should use this instead.
+func (d *Document) sourceDocument() *goquery.Document {
+	if d.source == nil {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(d.input))
+		if err != nil {
+			Logger.Println("Unable to create document", err)
+			return nil
+		}
+
+		d.source = doc
+	}
+
+	return d.source
+}
+
+// stripArchiveChrome removes the Wayback Machine's toolbar and restores the
+// original URLs of links and images that the archive rewrote to point at
+// itself.
+func (d *Document) stripArchiveChrome() {
+	d.document.Find(archiveChromeSelector).Each(func(i int, s *goquery.Selection) {
+		Logger.Printf("Removing archive chrome %s%s\n", s.Get(0).Data, getName(s))
+		removeNodes(s)
+	})
+
+	d.document.Find("a[href],img[src]").Each(func(i int, s *goquery.Selection) {
+		for _, attr := range []string{"href", "src"} {
+			if value, ok := s.Attr(attr); ok && archiveURLRegexp.MatchString(value) {
+				s.SetAttr(attr, archiveURLRegexp.ReplaceAllString(value, ""))
+			}
+		}
+	})
+}
+
+// preserveMathJax replaces the LaTeX scripts used by MathJax with their source
+// between the usual delimiters, and removes the rendered copies, so the
+// scripts are not thrown away with the rest.
+func (d *Document) preserveMathJax() {
+	removeNodes(d.document.Find(mathJaxRenderedSelector))
+
+	d.document.Find(mathJaxScriptSelector).Each(func(i int, s *goquery.Selection) {
+		tex := strings.TrimSpace(s.Text())
+		kind, _ := s.Attr("type")
+
+		open, close := `\(`, `\)`
+		if strings.Contains(kind, "mode=display") {
+			open, close = `\[`, `\]`
+		}
+
+		node := s.Get(0)
+		node.Parent.InsertBefore(&html.Node{Type: html.TextNode, Data: open + tex + close}, node)
+		node.Parent.RemoveChild(node)
+	})
+}
+
+// inferSemanticsFromStyle turns the styled <span> n into <strong> if it is
+// bold and <em> if it is italic, nesting an <em> within the <strong> if it is
+// both.
+func inferSemanticsFromStyle(n *html.Node) {
+	style := ""
+	for _, attr := range n.Attr {
+		if attr.Key == "style" {
+			style = attr.Val
+		}
+	}
+
+	bold := boldStyleRegexp.MatchString(style)
+	italic := italicStyleRegexp.MatchString(style)
+
+	switch {
+	case bold && italic:
+		em := &html.Node{Type: html.ElementNode, Data: "em", DataAtom: atom.Em}
+		for n.FirstChild != nil {
+			child := n.FirstChild
+			n.RemoveChild(child)
+			em.AppendChild(child)
+		}
+		n.AppendChild(em)
+		n.Data, n.DataAtom, n.Attr = "strong", atom.Strong, nil
+	case bold:
+		n.Data, n.DataAtom, n.Attr = "strong", atom.Strong, nil
+	case italic:
+		n.Data, n.DataAtom, n.Attr = "em", atom.Em, nil
+	}
+}
+
+// collapsePicture replaces the <picture> s with its fallback <img>, so that
+// responsive images are treated like any other. If the <img> has no src, the
+// largest image from the first <source> without a media query is used, or
+// from the first <source> at all.
+func collapsePicture(s *goquery.Selection) {
+	img := s.Find("img").First()
+	if img.Length() == 0 {
+		removeNodes(s)
+		return
+	}
+
+	if src, _ := img.Attr("src"); strings.TrimSpace(src) == "" {
+		sources := s.Find("source[srcset]")
+		if general := sources.Not("[media]"); general.Length() > 0 {
+			sources = general
+		}
+
+		if srcset, ok := sources.First().Attr("srcset"); ok {
+			img.SetAttr("src", largestSrcsetURL(srcset))
+		} else if srcset, ok := img.Attr("srcset"); ok {
+			img.SetAttr("src", largestSrcsetURL(srcset))
+		}
+	}
+
+	node := img.Get(0)
+	node.Parent.RemoveChild(node)
+
+	picture := s.Get(0)
+	picture.Parent.InsertBefore(node, picture)
+	picture.Parent.RemoveChild(picture)
+}
+
+// largestSrcsetURL returns the URL of the widest, or highest density, image in
+// a srcset.
+func largestSrcsetURL(srcset string) string {
+	best, size := "", -1.0
+	for _, candidate := range strings.Split(srcset, ",") {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// an image without a descriptor is 1x
+		value := 1.0
+		if len(fields) > 1 {
+			descriptor := strings.ToLower(fields[1])
+			if n, err := strconv.ParseFloat(strings.TrimRight(descriptor, "wx"), 64); err == nil {
+				value = n
+			}
+		}
+
+		if value > size {
+			best, size = fields[0], value
+		}
+	}
+
+	return best
+}
+
+// replaceSocialEmbeds replaces embedded tweets and posts, which are left as
+// blockquotes of the post's text, links and dates for a script to render,
+// with a paragraph linking to the post.
+func (d *Document) replaceSocialEmbeds() {
+	d.document.Find(socialEmbedSelector).Each(func(i int, s *goquery.Selection) {
+		link := ""
+		for _, attr := range []string{"data-instgrm-permalink", "cite", "data-href"} {
+			if value, ok := s.Attr(attr); ok && strings.TrimSpace(value) != "" {
+				link = value
+				break
+			}
+		}
+
+		// tweets end with a link to the tweet itself
+		if link == "" {
+			link, _ = s.Find("a[href]").Last().Attr("href")
+		}
+
+		if strings.TrimSpace(link) == "" {
+			return
+		}
+
+		link = html.EscapeString(d.resolveURL(link))
+		Logger.Printf("Replacing embedded post %s%s with %s\n", s.Get(0).Data, getName(s), link)
+		s.ReplaceWithHtml(fmt.Sprintf(`<p data-embed="post"><a href="%s">%s</a></p>`, link, link))
+	})
+}
+
+// removeWordBreak removes the <wbr> n, joining the text either side of it
+// back into one text node.
+func removeWordBreak(n *html.Node) {
+	parent := n.Parent
+	if parent == nil {
+		return
+	}
+
+	prev, next := n.PrevSibling, n.NextSibling
+	parent.RemoveChild(n)
+
+	if prev != nil && next != nil && prev.Type == html.TextNode && next.Type == html.TextNode {
+		prev.Data += next.Data
+		parent.RemoveChild(next)
+	}
+}
+
+// removeNonContentTags removes the elements in NonContentTags.
+func (d *Document) removeNonContentTags() {
+	// noscript might be valid, but probably not so we'll just remove it.
+	// template contents (including declarative shadow roots) are inert and
+	// never rendered, but the parser still exposes them as children
+	if len(d.NonContentTags) > 0 {
+		d.document.Find(strings.Join(d.NonContentTags, ",")).Each(func(i int, s *goquery.Selection) {
+			removeNodes(s)
+		})
+	}
+}
+
+// cleanDocument removes everything that is never content from the document,
+// ahead of looking for the article.
+func (d *Document) cleanDocument() {
+	if d.PreserveMath {
+		d.preserveMathJax()
+	}
+
+	d.removeNonContentTags()
+
+	if d.StripArchiveChrome {
+		d.stripArchiveChrome()
+	}
+
+	// custom elements from component based CMSes are scored like <div>s, and
+	// become paragraphs if they only hold text
+	if len(d.CustomBlockTags) > 0 {
+		d.document.Find(strings.Join(d.CustomBlockTags, ",")).Each(func(i int, s *goquery.Selection) {
+			node := s.Get(0)
+			node.Data, node.DataAtom = "div", atom.Div
+		})
+	}
+
+	d.replaceSocialEmbeds()
+
+	d.document.Find("picture").Each(func(i int, s *goquery.Selection) {
+		collapsePicture(s)
+	})
+
+	// pull quotes repeat a sentence of the article, see PullQuotes
+	removeNodes(d.document.Find(pullQuoteSelector))
+
+	// word break opportunities only matter when wrapping, and long URLs are
+	// littered with them
+	d.document.Find("wbr").Each(func(i int, s *goquery.Selection) {
+		removeWordBreak(s.Get(0))
+	})
+
+	if d.boilerplate != nil {
+		d.document.Find(boilerplateSelector).Each(func(i int, s *goquery.Selection) {
+			if d.boilerplate.isBoilerplate(s.Text()) {
+				Logger.Printf("Removing boilerplate %s%s\n", s.Get(0).Data, getName(s))
+				removeNodes(s)
+			}
+		})
+	}
+
+	// like the blacklist, but also applied when retrying without removing
+	// unlikely candidates
+	if d.ForceRemoveRegexp != nil {
+		d.document.Find("*").Not("html,body").Each(func(i int, s *goquery.Selection) {
+			class, _ := s.Attr("class")
+			id, _ := s.Attr("id")
+
+			if (class != "" && d.ForceRemoveRegexp.MatchString(class)) || (id != "" && d.ForceRemoveRegexp.MatchString(id)) {
+				Logger.Printf("Removing forced noise - %s%s\n", class, id)
+				removeNodes(s)
+			}
+		})
+	}
+
+	if d.RemoveUnlikelyCandidates {
+		start := d.phaseStart()
+		d.removeUnlikelyCandidates()
+		d.phaseDone("removeUnlikely", start)
+	}
+}
+
+func (d *Document) prepareCandidates() {
+	d.transformMisusedDivsIntoParagraphs()
+
+	start := d.phaseStart()
+	d.scoreParagraphs(d.MinTextLength)
+	d.phaseDone("scoreParagraphs", start)
+
+	start = d.phaseStart()
+	d.selectBestCandidate()
+	d.phaseDone("selectBest", start)
+
+	if d.TableLayoutMode {
+		d.transformContentCellsIntoParagraphs()
+	}
+}
+
+// transformContentCellsIntoParagraphs turns the innermost table cells of the
+// best candidate into <p>s. In table layouts the tables are the layout itself,
+// so the cells holding text are kept as paragraphs rather than being flattened
+// together.
+func (d *Document) transformContentCellsIntoParagraphs() {
+	d.bestCandidate.selection.Find("td").Not(":has(table,p,div)").Each(func(i int, s *goquery.Selection) {
+		if len(strings.TrimSpace(s.Text())) > 0 {
+			s.Get(0).Data = "p"
+		}
+	})
+}
+
+// phaseStart returns the start time of an extraction phase, avoiding the
+// clock entirely when nobody is listening.
+func (d *Document) phaseStart() time.Time {
+	if d.OnPhase == nil {
+		return time.Time{}
+	}
+
+	return time.Now()
+}
+
+// phaseDone reports the duration of an extraction phase to OnPhase.
+func (d *Document) phaseDone(phase string, start time.Time) {
+	if d.OnPhase != nil {
+		d.OnPhase(phase, time.Since(start))
+	}
+}
+
+func (d *Document) selectBestCandidate() {
+	var best *candidate
+
+	d.semantic = false
+	if article := d.semanticArticle(); article != nil {
+		best = article
+		d.semantic = true
+	} else {
+		for _, c := range d.candidates {
+			if best == nil {
+				best = c
+			} else if best.score < c.score {
+				best = c
+			}
+		}
+	}
+
+	if best == nil {
+		// malformed pages can end up with more than one body, only use the
+		// first so content isn't counted twice
+		best = &candidate{selection: d.document.Find("body").First()}
+	}
+
+	// minimal pages can put the whole article in one paragraph directly in
+	// the body, which would otherwise bring the rest of the body with it
+	if best.selection.Is("body") {
+		if p := dominantParagraph(best.selection); p != nil {
+			Logger.Println("Using the dominant paragraph of the body as the article")
+			best = &candidate{selection: p, score: best.score, breakdown: best.breakdown}
+		}
+	}
+
+	d.bestCandidate = best
+
+	// a lead image in the best candidate is protected from cleaning
+	d.BestCandidateHasImage = leadImage(best.selection) != nil
+}
+
+// dominantParagraph returns the longest <p> directly within s if it holds at
+// least half of the text of s, or nil otherwise.
+func dominantParagraph(s *goquery.Selection) *goquery.Selection {
+	var longest *goquery.Selection
+	longestLength := 0
+
+	s.ChildrenFiltered("p").Each(func(i int, p *goquery.Selection) {
+		if length := len(strings.TrimSpace(p.Text())); length > longestLength {
+			longest, longestLength = p, length
+		}
+	})
+
+	if longest == nil || longestLength*2 < len(strings.TrimSpace(s.Text())) {
+		return nil
+	}
+
+	return longest
+}
+
+// semanticArticle returns the page's <article> as the best candidate when it
+// holds most of the text on the page. When it only holds a substantial amount
+// of text it is boosted instead. Pages with several <article>s are most likely
+// listings, so they are left to the usual heuristics.
+func (d *Document) semanticArticle() *candidate {
+	article := d.document.Find("article")
+	if article.Length() != 1 {
+		return nil
+	}
+
+	length := len(strings.TrimSpace(article.Text()))
+	if length < d.RetryLength {
+		return nil
+	}
+
+	node := article.Get(0)
+	c, ok := d.candidates[node]
+	if !ok {
+		c = d.scoreNode(article)
+		c.breakdown.LinkDensityMultiplier = 1
+		c.breakdown.Score = c.score
+		d.candidates[node] = c
+	}
+
+	if float32(length) >= float32(len(strings.TrimSpace(d.document.Find("body").First().Text())))*.5 {
+		Logger.Printf("Using <article> with length %d as the best candidate\n", length)
+		return c
+	}
+
+	c.score += 50
+	c.breakdown.ArticleBonus += 50
+	c.breakdown.Score = c.score
+	return nil
+}
+
+// isHeroImageWrapper returns whether s holds little more than a lead image.
+func (d *Document) isHeroImageWrapper(s *goquery.Selection) bool {
+	return leadImage(s) != nil && len(strings.TrimSpace(s.Text())) < d.MinTextLength
+}
+
+// leadImage returns the first image within s, unless its declared dimensions
+// suggest it is an icon or spacer rather than a photo.
+func leadImage(s *goquery.Selection) *html.Node {
+	img := s.Find("img").First()
+	if img.Length() == 0 {
+		return nil
+	}
+
+	for _, attr := range []string{"width", "height"} {
+		if value, ok := img.Attr(attr); ok {
+			if size, err := strconv.Atoi(strings.TrimSuffix(value, "px")); err == nil && size < 100 {
+				return nil
+			}
+		}
+	}
+
+	return img.Get(0)
+}
+
+func (d *Document) getArticle() string {
+	output := bytes.NewBufferString("<div>")
+	d.articleNodes = nil
+
+	siblingScoreThreshold := float32(math.Max(10, float64(d.bestCandidate.score*.2)))
+
+	// Union would put the best candidate after its siblings, so walk the
+	// parent's children instead to keep everything in document order
+	siblings := d.bestCandidate.selection.Parent().Children()
+
+	// an explicit <article> is the whole of the content
+	if siblings.Length() == 0 || d.semantic {
+		siblings = d.bestCandidate.selection
+	}
+
+	// an article split into columns is all of the columns
+	columns := make(map[*html.Node]bool)
+	if d.MergeColumns && !d.semantic {
+		if s := d.contentColumns(); s != nil {
+			Logger.Printf("Merging %d content columns\n", s.Length())
+			siblings = s
+			for _, n := range s.Nodes {
+				columns[n] = true
+			}
+		}
+	}
+
+	// siblings that make the cut, in document order, with their scores.
+	// Paragraphs included on their own merit have no score of their own.
+	included := make([]*goquery.Selection, 0)
+	scores := make(map[*html.Node]float32)
+
+	siblings.Each(func(i int, s *goquery.Selection) {
+		include := false
+		n := s.Get(0)
+
+		if n == d.bestCandidate.Node() || columns[n] {
+			include = true
+		} else if c, ok := d.candidates[n]; ok && c.score >= siblingScoreThreshold {
+			include = true
+			scores[n] = c.score
+		}
+
+		if s.Is("p") {
+			linkDensity := d.getLinkDensity(s)
+			content := s.Text()
+			contentLength := len(content)
+
+			if contentLength >= d.SiblingParagraphMinLength && linkDensity < d.SiblingParagraphMaxLinkDensity {
+				include = true
+			} else if contentLength < d.SiblingParagraphMinLength && linkDensity == 0 {
+				include = d.SentenceRegexp != nil && d.SentenceRegexp.MatchString(content)
+			}
+		}
+
+		if include {
+			included = append(included, s)
+		}
+	})
 
-	blacklistCandidatesRegexp  = regexp.MustCompile(`(?i)popupbody`)
-	okMaybeItsACandidateRegexp = regexp.MustCompile(`(?i)and|article|body|column|main|shadow`)
-	unlikelyCandidatesRegexp   = regexp.MustCompile(`(?i)combx|comment|community|hidden|disqus|modal|extra|foot|header|menu|remark|rss|shoutbox|sidebar|sponsor|ad-break|agegate|pagination|pager|popup`)
-	divToPElementsRegexp       = regexp.MustCompile(`(?i)<(a|blockquote|dl|div|img|ol|p|pre|table|ul)`)
+	// keep only the best scoring siblings when there are too many.
+	// Paragraphs included on their own merit are real content, and without
+	// a score to rank them by they are always kept
+	ranked := make([]*html.Node, 0, len(included))
+	for _, s := range included {
+		if n := s.Get(0); n != d.bestCandidate.Node() {
+			if _, ok := scores[n]; ok {
+				ranked = append(ranked, n)
+			}
+		}
+	}
 
-	negativeRegexp = regexp.MustCompile(`(?i)combx|comment|com-|foot|footer|footnote|masthead|media|meta|outbrain|promo|related|scroll|shoutbox|sidebar|sponsor|shopping|tags|tool|widget`)
-	positiveRegexp = regexp.MustCompile(`(?i)article|body|content|entry|hentry|main|page|pagination|post|text|blog|story`)
+	if d.MaxSiblingAppends > 0 && len(ranked) > d.MaxSiblingAppends {
+		sort.SliceStable(ranked, func(i, j int) bool {
+			return scores[ranked[i]] > scores[ranked[j]]
+		})
 
-	stripCommentRegexp = regexp.MustCompile(`(?s)\<\!\-{2}.+?-{2}\>`)
+		drop := make(map[*html.Node]bool)
+		for _, n := range ranked[d.MaxSiblingAppends:] {
+			drop[n] = true
+		}
+
+		kept := make([]*goquery.Selection, 0, len(included))
+		for _, s := range included {
+			if !drop[s.Get(0)] {
+				kept = append(kept, s)
+			}
+		}
+		included = kept
+	}
 
-	sentenceRegexp = regexp.MustCompile(`\.( |$)`)
+	for _, s := range included {
+		n := s.Get(0)
+		tag := "div"
+		if s.Is("p") {
+			tag = n.Data
+		}
 
-	normalizeWhitespaceRegexp = regexp.MustCompile(`[\r\n\f]+`)
-)
+		html, _ := s.Html()
+		fmt.Fprintf(output, "<%s>%s</%s>", tag, html, tag)
+		d.articleNodes = append(d.articleNodes, n)
+	}
 
-type candidate struct {
-	selection *goquery.Selection
-	score     float32
-}
+	// endnotes tend to score poorly, so add them to the end of the article
+	// unless they're already part of it
+	if d.PreserveFootnotes {
+		if notes := d.footnotes(); notes != nil {
+			html, _ := goquery.OuterHtml(goquery.NewDocumentFromNode(notes).Selection)
+			output.WriteString(html)
+			d.articleNodes = append(d.articleNodes, notes)
+		}
+	}
 
-func (c *candidate) Node() *html.Node {
-	return c.selection.Get(0)
+	output.Write([]byte("</div>"))
+
+	return output.String()
 }
 
-type Document struct {
-	input         string
-	document      *goquery.Document
-	content       string
-	candidates    map[*html.Node]*candidate
-	bestCandidate *candidate
+// footnotes returns the last notes section in the document that isn't within
+// the article nodes, or nil if there isn't one.
+func (d *Document) footnotes() *html.Node {
+	notes := d.document.Find(footnotesSelector).Last()
+	if notes.Length() == 0 {
+		return nil
+	}
 
-	RemoveUnlikelyCandidates bool
-	WeightClasses            bool
-	CleanConditionally       bool
-	BestCandidateHasImage    bool
-	RetryLength              int
-	MinTextLength            int
-	RemoveEmptyNodes         bool
-	WhitelistTags            []string
-}
-
-func NewDocument(s string) (*Document, error) {
-	d := &Document{
-		input:                    s,
-		WhitelistTags:            []string{"div", "p"},
-		RemoveUnlikelyCandidates: true,
-		WeightClasses:            true,
-		CleanConditionally:       true,
-		RetryLength:              250,
-		MinTextLength:            25,
-		RemoveEmptyNodes:         true,
+	for n := notes.Get(0); n != nil; n = n.Parent {
+		for _, article := range d.articleNodes {
+			if n == article {
+				return nil
+			}
+		}
 	}
-	err := d.initializeHtml(s)
+
+	return notes.Get(0)
+}
+
+// semanticOutput wraps the sanitized content in an <article>, headed by the
+// title in an <h1>. If the content starts with the title, that copy of it is
+// dropped.
+func (d *Document) semanticOutput(content string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
 	if err != nil {
-		return nil, err
+		Logger.Println("Unable to create document", err)
+		return content
 	}
 
-	return d, nil
+	body := doc.Find("body").First()
+	title := d.Title()
+
+	if first := leadingTitle(body.Get(0), title); first != nil {
+		first.Parent.RemoveChild(first)
+	}
+
+	inner, _ := body.Html()
+
+	output := bytes.NewBufferString("<article>")
+	if title != "" {
+		fmt.Fprintf(output, "<header><h1>%s</h1></header>", html.EscapeString(title))
+	}
+	output.WriteString(inner)
+	output.WriteString("</article>")
+
+	body.SetHtml(output.String())
+
+	text, _ := doc.Html()
+	return text
 }
 
-func (d *Document) initializeHtml(s string) error {
-	// replace consecutive <br>'s with p tags
-	s = replaceBrsRegexp.ReplaceAllString(s, "</p><p>")
+// includeTitle adds the title as an <h1> at the start of the sanitized
+// content, unless it is already there. If the content starts with the title
+// as plain text, that copy of it is dropped.
+func (d *Document) includeTitle(content string) string {
+	title := d.Title()
+	if title == "" {
+		return content
+	}
 
-	// replace font tags
-	s = replaceFontsRegexp.ReplaceAllString(s, `<${1}span>`)
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		Logger.Println("Unable to create document", err)
+		return content
+	}
 
-	// manually strip regexps since html parser seems to miss some
-	s = stripCommentRegexp.ReplaceAllString(s, "")
+	body := doc.Find("body").First()
 
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(s))
+	if first := leadingTitle(body.Get(0), title); first != nil {
+		if first.Parent.Data == "h1" {
+			return content
+		}
+
+		first.Parent.RemoveChild(first)
+	}
+
+	body.PrependHtml(fmt.Sprintf("<h1>%s</h1>", html.EscapeString(title)))
+
+	text, _ := doc.Html()
+	return text
+}
+
+// includeSubtitle puts the subtitle at the top of the content as a lead
+// paragraph, after the title if it was included, removing it from wherever it
+// already was.
+func (d *Document) includeSubtitle(content string) string {
+	subtitle := d.Subtitle()
+	if subtitle == "" {
+		return content
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
 	if err != nil {
-		return err
+		Logger.Println("Unable to create document", err)
+		return content
 	}
 
-	// if no body (like from a redirect or empty string)
-	if doc.Find("body").Length() == 0 {
-		s = "<body/>"
-		return d.initializeHtml(s)
+	body := doc.Find("body").First()
+
+	body.Find("*").FilterFunction(func(i int, s *goquery.Selection) bool {
+		return strings.Join(strings.Fields(s.Text()), " ") == subtitle
+	}).Last().Remove()
+
+	lead := fmt.Sprintf(`<p class="lead">%s</p>`, html.EscapeString(subtitle))
+	if first := body.Children().First(); first.Is("h1") {
+		first.AfterHtml(lead)
+	} else {
+		body.PrependHtml(lead)
+	}
+
+	text, _ := doc.Html()
+	return text
+}
+
+// leadingTitle returns the first text node within n if it is the title, or
+// nil otherwise.
+func leadingTitle(n *html.Node, title string) *html.Node {
+	if first := firstText(n); first != nil && title != "" && strings.TrimSpace(first.Data) == title {
+		return first
 	}
 
-	d.document = doc
 	return nil
 }
 
-func (d *Document) Content() string {
-	if d.content == "" {
-		d.prepareCandidates()
+// firstText returns the first text node within n that isn't just whitespace.
+func firstText(n *html.Node) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode && strings.TrimSpace(c.Data) != "" {
+			return c
+		}
 
-		article := d.getArticle()
-		articleText := d.sanitize(article)
+		if t := firstText(c); t != nil {
+			return t
+		}
+	}
 
-		length := len(strings.TrimSpace(articleText))
-		if length < d.RetryLength {
-			retry := true
-
-			if d.RemoveUnlikelyCandidates {
-				d.RemoveUnlikelyCandidates = false
-			} else if d.WeightClasses {
-				d.WeightClasses = false
-			} else if d.CleanConditionally {
-				d.CleanConditionally = false
-			} else {
-				d.content = articleText
-				retry = false
-			}
+	return nil
+}
 
-			if retry {
-				Logger.Printf("Retrying with length %d < retry length %d\n", length, d.RetryLength)
-				d.initializeHtml(d.input)
-				articleText = d.Content()
-			}
+// contentColumns returns the columns an article is split across, such as on
+// print-style pages, or nil if it isn't. These are the nearest column around
+// the best candidate, by class, and its sibling columns that share the same
+// column class and hold content scoring at least half as well. Grid layouts
+// such as Bootstrap's col-md-8 and col-md-4 give each column a class of its
+// own, so their sidebars aren't merged.
+func (d *Document) contentColumns() *goquery.Selection {
+	for s := d.bestCandidate.selection; s.Length() > 0 && !s.Is("body"); s = s.Parent() {
+		classes := columnClasses(s)
+		if len(classes) == 0 {
+			continue
 		}
 
-		d.content = articleText
+		threshold := d.columnScore(s) * .5
+		columns := s.Parent().Children().FilterFunction(func(i int, sibling *goquery.Selection) bool {
+			for class := range columnClasses(sibling) {
+				if classes[class] {
+					return d.columnScore(sibling) >= threshold
+				}
+			}
+			return false
+		})
+
+		if columns.Length() > 1 {
+			return columns
+		}
 	}
 
-	return d.content
+	return nil
 }
 
-func (d *Document) prepareCandidates() {
-	// noscript might be valid, but probably not so we'll just remove it
-	d.document.Find("script,style,noscript").Each(func(i int, s *goquery.Selection) {
-		removeNodes(s)
-	})
+// columnClasses returns the classes of s that mark it as a column.
+func columnClasses(s *goquery.Selection) map[string]bool {
+	class, _ := s.Attr("class")
 
-	if d.RemoveUnlikelyCandidates {
-		d.removeUnlikelyCandidates()
+	classes := make(map[string]bool)
+	for _, c := range strings.Fields(class) {
+		if columnRegexp.MatchString(c) {
+			classes[c] = true
+		}
 	}
 
-	d.transformMisusedDivsIntoParagraphs()
-	d.scoreParagraphs(d.MinTextLength)
-	d.selectBestCandidate()
+	return classes
 }
 
-func (d *Document) selectBestCandidate() {
-	var best *candidate
+// columnScore returns the score of the best candidate within s.
+func (d *Document) columnScore(s *goquery.Selection) float32 {
+	score := float32(0)
+	column := s.Get(0)
 
-	for _, c := range d.candidates {
-		if best == nil {
-			best = c
-		} else if best.score < c.score {
-			best = c
+	for n, c := range d.candidates {
+		if c.score <= score {
+			continue
 		}
-	}
 
-	if best == nil {
-		best = &candidate{d.document.Find("body"), 0}
+		for p := n; p != nil; p = p.Parent {
+			if p == column {
+				score = c.score
+				break
+			}
+		}
 	}
 
-	d.bestCandidate = best
+	return score
 }
 
-func (d *Document) getArticle() string {
+// getWebStory returns the text of each page of an AMP web story, in order,
+// with a <div> per page and a <p> per block of text.
+func (d *Document) getWebStory(story *goquery.Selection) string {
 	output := bytes.NewBufferString("<div>")
+	d.bestCandidate = &candidate{selection: story}
+	d.articleNodes = nil
 
-	siblingScoreThreshold := float32(math.Max(10, float64(d.bestCandidate.score*.2)))
+	story.Find("amp-story-page").Each(func(i int, page *goquery.Selection) {
+		output.WriteString("<div>")
+		for _, block := range d.textBlocks(page.Get(0)) {
+			fmt.Fprintf(output, "<p>%s</p>", html.EscapeString(block.text))
+		}
+		output.WriteString("</div>")
 
-	d.bestCandidate.selection.Siblings().Union(d.bestCandidate.selection).Each(func(i int, s *goquery.Selection) {
-		append := false
-		n := s.Get(0)
+		d.articleNodes = append(d.articleNodes, page.Get(0))
+	})
 
-		if n == d.bestCandidate.Node() {
-			append = true
-		} else if c, ok := d.candidates[n]; ok && c.score >= siblingScoreThreshold {
-			append = true
-		}
+	output.WriteString("</div>")
 
-		if s.Is("p") {
-			linkDensity := d.getLinkDensity(s)
-			content := s.Text()
-			contentLength := len(content)
+	return output.String()
+}
+
+// liveBlogEntries returns the outermost live blog entries in the document.
+func (d *Document) liveBlogEntries() *goquery.Selection {
+	return d.document.Find(liveBlogSelector).FilterFunction(func(i int, s *goquery.Selection) bool {
+		return s.ParentsFiltered(liveBlogSelector).Length() == 0
+	})
+}
+
+// getLiveBlog returns each live blog entry in chronological order, with a
+// <div> per entry starting with its timestamp.
+func (d *Document) getLiveBlog(entries *goquery.Selection) string {
+	type liveBlogEntry struct {
+		node      *html.Node
+		selection *goquery.Selection
+		timestamp time.Time
+		label     string
+	}
 
-			if contentLength >= 80 && linkDensity < .25 {
-				append = true
-			} else if contentLength < 80 && linkDensity == 0 {
-				append = sentenceRegexp.MatchString(content)
+	list := make([]liveBlogEntry, 0, entries.Length())
+	entries.Each(func(i int, s *goquery.Selection) {
+		entry := liveBlogEntry{node: s.Get(0), selection: s.Clone()}
+
+		if stamp, ok := s.Attr("data-timestamp"); ok {
+			if seconds, err := strconv.ParseInt(strings.TrimSpace(stamp), 10, 64); err == nil {
+				entry.timestamp = time.Unix(seconds, 0).UTC()
+			} else if t, ok := parseDate(stamp); ok {
+				entry.timestamp = t
 			}
 		}
 
-		if append {
-			tag := "div"
-			if s.Is("p") {
-				tag = n.Data
+		// the entry's own <time> becomes the heading, so drop it from the body
+		if t := entry.selection.Find("time[datetime]").First(); t.Length() > 0 {
+			if entry.timestamp.IsZero() {
+				datetime, _ := t.Attr("datetime")
+				entry.timestamp, _ = parseDate(datetime)
 			}
+			entry.label = strings.TrimSpace(t.Text())
+			removeNodes(t)
+		}
 
-			html, _ := s.Html()
-			fmt.Fprintf(output, "<%s>%s</%s>", tag, html, tag)
+		if entry.label == "" && !entry.timestamp.IsZero() {
+			entry.label = entry.timestamp.Format("2006-01-02 15:04")
 		}
+
+		list = append(list, entry)
 	})
 
-	output.Write([]byte("</div>"))
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].timestamp.Before(list[j].timestamp)
+	})
+
+	output := bytes.NewBufferString("<div>")
+	d.bestCandidate = &candidate{selection: entries.Parent().First()}
+	d.articleNodes = nil
+
+	for _, entry := range list {
+		output.WriteString("<div>")
+		if !entry.timestamp.IsZero() {
+			fmt.Fprintf(output, `<p><time datetime="%s">%s</time></p>`, entry.timestamp.Format(time.RFC3339), html.EscapeString(entry.label))
+		}
+
+		content, _ := entry.selection.Html()
+		output.WriteString(content)
+		output.WriteString("</div>")
+
+		d.articleNodes = append(d.articleNodes, entry.node)
+	}
+
+	output.WriteString("</div>")
+
+	return output.String()
+}
+
+// densityBlock is the text directly within a block level element, ignoring
+// the text of nested blocks.
+type densityBlock struct {
+	text       string
+	textLength int
+	tags       int
+}
+
+// density is the ratio of non-link text to tags within the block.
+func (b densityBlock) density() float64 {
+	return float64(b.textLength) / float64(b.tags)
+}
+
+var densityBlockTags = map[string]bool{
+	"article": true,
+	"aside":   true,
+	"body":    true,
+	"footer":  true,
+	"form":    true,
+	"header":  true,
+	"main":    true,
+	"nav":     true,
+	"section": true,
+}
+
+// getDensestRegion finds the contiguous run of blocks in the document whose
+// text to tag ratios are all at least that of the page as a whole, and which
+// holds the most text. It makes no use of class names, so it can rescue pages
+// where the scoring heuristics fail.
+func (d *Document) getDensestRegion() string {
+	blocks := densityBlocks(d.document.Find("body").First().Get(0))
+
+	totalText, totalTags := 0, 0
+	for _, b := range blocks {
+		totalText += b.textLength
+		totalTags += b.tags
+	}
+
+	if totalText == 0 {
+		return ""
+	}
+
+	threshold := float64(totalText) / float64(totalTags)
+
+	bestStart, bestEnd, bestLength := 0, 0, 0
+	start, length := 0, 0
+	for i, b := range blocks {
+		if b.density() < threshold {
+			start, length = i+1, 0
+			continue
+		}
+
+		length += b.textLength
+		if length > bestLength {
+			bestStart, bestEnd, bestLength = start, i+1, length
+		}
+	}
+
+	output := bytes.NewBufferString("<div>")
+	for _, b := range blocks[bestStart:bestEnd] {
+		fmt.Fprintf(output, "<p>%s</p>", html.EscapeString(b.text))
+	}
+	output.WriteString("</div>")
 
 	return output.String()
 }
 
+// densityBlocks returns the blocks within n in document order. Blocks that
+// only wrap other blocks are left out so they don't split runs of text.
+func densityBlocks(n *html.Node) []densityBlock {
+	blocks := make([]densityBlock, 0)
+	if n == nil {
+		return blocks
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		var text bytes.Buffer
+		block := densityBlock{tags: 1}
+		nested := make([]*html.Node, 0)
+
+		var inline func(n *html.Node, link bool)
+		inline = func(n *html.Node, link bool) {
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				switch c.Type {
+				case html.TextNode:
+					text.WriteString(c.Data)
+					if !link {
+						block.textLength += len(strings.Join(strings.Fields(c.Data), " "))
+					}
+				case html.ElementNode:
+					if blockTags[c.Data] || densityBlockTags[c.Data] {
+						nested = append(nested, c)
+					} else {
+						block.tags++
+						inline(c, link || c.Data == "a")
+					}
+				}
+			}
+		}
+
+		inline(n, false)
+		block.text = strings.Join(strings.Fields(text.String()), " ")
+
+		if block.text != "" || block.tags > 1 {
+			blocks = append(blocks, block)
+		}
+
+		for _, c := range nested {
+			walk(c)
+		}
+	}
+
+	walk(n)
+
+	return blocks
+}
+
 func (d *Document) removeUnlikelyCandidates() {
-	d.document.Find("*").Not("html,body").Each(func(i int, s *goquery.Selection) {
+	// forced content, and everything wrapping it, is never unlikely. The same
+	// goes for hidden content when it is included
+	protected := make(map[*html.Node]bool)
+	if d.ForceContentRegexp != nil {
+		d.document.Find("*").Each(func(i int, s *goquery.Selection) {
+			if d.isForcedContent(s) {
+				for n := s.Get(0); n != nil && !protected[n]; n = n.Parent {
+					protected[n] = true
+				}
+			}
+		})
+	}
+
+	// pages that wait for consent before revealing the article still have
+	// it in the page, just hidden
+	if d.IncludeHiddenContent {
+		d.document.Find("body *").Each(func(i int, s *goquery.Selection) {
+			if isHidden(s) && d.holdsBulkOfText(s) {
+				Logger.Printf("Keeping hidden content %s%s\n", s.Get(0).Data, getName(s))
+				for n := s.Get(0); n != nil && !protected[n]; n = n.Parent {
+					protected[n] = true
+				}
+			}
+		})
+	}
+
+	// collapsed <details> are often marked hidden, but hold real content
+	d.document.Find("*").Not("html,body,details,summary").Each(func(i int, s *goquery.Selection) {
 		class, _ := s.Attr("class")
 		id, _ := s.Attr("id")
 
 		str := class + id
 
-		if blacklistCandidatesRegexp.MatchString(str) || (unlikelyCandidatesRegexp.MatchString(str) && !okMaybeItsACandidateRegexp.MatchString(str)) {
+		if protected[s.Get(0)] {
+			return
+		}
+
+		// notes sections are usually classed footnotes, which looks unlikely
+		if d.PreserveFootnotes && s.Closest(footnotesSelector).Length() > 0 {
+			return
+		}
+
+		// header wrappers around a lead image are kept so the image can be
+		// considered part of the article
+		if heroWrapperRegexp.MatchString(str) && d.isHeroImageWrapper(s) {
+			return
+		}
+
+		// HTML5 navigation and asides are never the article, however much
+		// text they hold
+		if s.Is(navigationSelector) || blacklistCandidatesRegexp.MatchString(str) || (unlikelyCandidatesRegexp.MatchString(str) && !okMaybeItsACandidateRegexp.MatchString(str)) {
+			// unclosed tags can leave the article nested inside an unlikely
+			// wrapper, so move it out before removing the wrapper
+			if content := d.misnestedContent(s); content != nil {
+				Logger.Printf("Moving misnested content out of unlikely candidate - %s\n", str)
+				node := s.Get(0)
+				content.Parent.RemoveChild(content)
+				node.Parent.InsertBefore(content, node)
+			}
+
 			Logger.Printf("Removing unlikely candidate - %s\n", str)
 			removeNodes(s)
 		}
 	})
 }
 
+// isHidden returns whether s is hidden by its class or id, its attributes or
+// its style.
+func isHidden(s *goquery.Selection) bool {
+	class, _ := s.Attr("class")
+	id, _ := s.Attr("id")
+	style, _ := s.Attr("style")
+	ariaHidden, _ := s.Attr("aria-hidden")
+	_, hidden := s.Attr("hidden")
+
+	return hidden || ariaHidden == "true" || hiddenRegexp.MatchString(class+" "+id) || hiddenStyleRegexp.MatchString(style)
+}
+
+// holdsBulkOfText returns whether s has at least RetryLength characters of
+// text, and at least half of the text in the body. Hidden menus and dialogs
+// never do.
+func (d *Document) holdsBulkOfText(s *goquery.Selection) bool {
+	length := len(strings.TrimSpace(s.Text()))
+	body := len(strings.TrimSpace(d.document.Find("body").First().Text()))
+
+	return length >= d.RetryLength && length*2 >= body
+}
+
+// misnestedContent returns the first descendant of s that looks like article
+// content and holds a substantial amount of text.
+func (d *Document) misnestedContent(s *goquery.Selection) *html.Node {
+	if s.Parent().Length() == 0 {
+		return nil
+	}
+
+	var content *html.Node
+
+	s.Find("*").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		class, _ := s.Attr("class")
+		id, _ := s.Attr("id")
+		str := class + id
+
+		if !positiveRegexp.MatchString(str) || unlikelyCandidatesRegexp.MatchString(str) || negativeRegexp.MatchString(str) {
+			return true
+		}
+
+		if len(strings.TrimSpace(s.Text())) >= d.RetryLength {
+			content = s.Get(0)
+			return false
+		}
+
+		return true
+	})
+
+	return content
+}
+
 func (d *Document) transformMisusedDivsIntoParagraphs() {
+	// treat <details> as if they were expanded so their contents are scored
+	// like any other block
+	d.document.Find("details").Each(func(i int, s *goquery.Selection) {
+		s.Get(0).Data = "div"
+	})
+
 	d.document.Find("div").Each(func(i int, s *goquery.Selection) {
 		html, err := s.Html()
 		if err != nil {
@@ -253,6 +3672,12 @@ func (d *Document) scoreParagraphs(minimumTextLength int) {
 	candidates := make(map[*html.Node]*candidate)
 
 	d.document.Find("p,td").Each(func(i int, s *goquery.Selection) {
+		// in table layouts only the innermost cells hold content, the rest
+		// would count the same text again
+		if d.TableLayoutMode && s.Is("td") && s.Find("table").Length() > 0 {
+			return
+		}
+
 		text := strings.TrimSpace(s.Text())
 
 		// if this paragraph is less than x chars, don't count it
@@ -278,12 +3703,15 @@ func (d *Document) scoreParagraphs(minimumTextLength int) {
 			}
 		}
 
-		contentScore := float32(1.0)
-		contentScore += float32(strings.Count(text, ",") + 1)
-		contentScore += float32(math.Min(float64(int(len(text)/100.0)), 3))
+		paragraphBonus := float32(1.0)
+		commaBonus := float32(strings.Count(text, ",")+1) * d.CommaWeight
+		lengthBonus := float32(math.Min(float64(int(len(text)/100.0)), float64(d.LengthBonusCap)))
+		contentScore := paragraphBonus + commaBonus + lengthBonus
 
+		candidates[parentNode].addBonus(paragraphBonus, commaBonus, lengthBonus)
 		candidates[parentNode].score += contentScore
 		if grandparentNode != nil {
+			candidates[grandparentNode].addBonus(paragraphBonus/2.0, commaBonus/2.0, lengthBonus/2.0)
 			candidates[grandparentNode].score += contentScore / 2.0
 		}
 	})
@@ -291,8 +3719,19 @@ func (d *Document) scoreParagraphs(minimumTextLength int) {
 	// scale the final candidates score based on link density. Good content
 	// should have a relatively small link density (5% or less) and be mostly
 	// unaffected by this operation
-	for _, candidate := range candidates {
-		candidate.score = candidate.score * (1 - d.getLinkDensity(candidate.selection))
+	for node, candidate := range candidates {
+		linkDensity := d.getLinkDensity(candidate.selection)
+
+		// lists of links are never the article, however long
+		if linkDensity > d.MaxCandidateLinkDensity {
+			Logger.Printf("Rejecting candidate %s%s with link density %f\n", node.Data, getName(candidate.selection), linkDensity)
+			delete(candidates, node)
+			continue
+		}
+
+		candidate.breakdown.LinkDensityMultiplier = 1 - linkDensity
+		candidate.score = candidate.score * candidate.breakdown.LinkDensityMultiplier
+		candidate.breakdown.Score = candidate.score
 	}
 
 	d.candidates = candidates
@@ -309,8 +3748,27 @@ func (d *Document) getLinkDensity(s *goquery.Selection) float32 {
 	return float32(linkLength) / float32(textLength)
 }
 
+// isForcedContent returns whether the class or id of s matches
+// ForceContentRegexp.
+func (d *Document) isForcedContent(s *goquery.Selection) bool {
+	if d.ForceContentRegexp == nil {
+		return false
+	}
+
+	class, _ := s.Attr("class")
+	id, _ := s.Attr("id")
+
+	return (class != "" && d.ForceContentRegexp.MatchString(class)) || (id != "" && d.ForceContentRegexp.MatchString(id))
+}
+
 func (d *Document) classWeight(s *goquery.Selection) int {
 	weight := 0
+
+	// forced content is boosted regardless of WeightClasses
+	if d.isForcedContent(s) {
+		weight += 25
+	}
+
 	if !d.WeightClasses {
 		return weight
 	}
@@ -318,6 +3776,10 @@ func (d *Document) classWeight(s *goquery.Selection) int {
 	class, _ := s.Attr("class")
 	id, _ := s.Attr("id")
 
+	if s.Closest(navigationSelector).Length() > 0 {
+		weight -= 25
+	}
+
 	if class != "" {
 		if negativeRegexp.MatchString(class) {
 			weight -= 25
@@ -341,17 +3803,52 @@ func (d *Document) classWeight(s *goquery.Selection) int {
 	return weight
 }
 
+// overridingTags are the tags whose score in TagScores is used in place of
+// their class weight, rather than being added to it.
+var overridingTags = map[string]bool{
+	"blockquote": true,
+	"fieldset":   true,
+	"form":       true,
+}
+
 func (d *Document) scoreNode(s *goquery.Selection) *candidate {
-	contentScore := d.classWeight(s)
-	if s.Is("div") {
-		contentScore += 5
-	} else if s.Is("blockquote,form,fieldset") {
-		contentScore = 3
-	} else if s.Is("th") {
-		contentScore -= 5
+	weight := d.classWeight(s)
+	name := goquery.NodeName(s)
+	if overridingTags[name] {
+		weight = 0
+	}
+	contentScore := weight + d.TagScores[name]
+
+	return &candidate{
+		selection: s,
+		score:     float32(contentScore),
+		breakdown: ScoreBreakdown{
+			TagScore:    float32(contentScore - weight),
+			ClassWeight: float32(weight),
+		},
+	}
+}
+
+func (c *candidate) addBonus(paragraph, comma, length float32) {
+	c.breakdown.ParagraphBonus += paragraph
+	c.breakdown.CommaBonus += comma
+	c.breakdown.LengthBonus += length
+}
+
+// ScoreBreakdowns returns how the content score of each candidate node was
+// calculated during the last extraction, for comparison with other
+// readability implementations. The nodes point into the live document tree.
+func (d *Document) ScoreBreakdowns() map[*html.Node]ScoreBreakdown {
+	if d.content == "" {
+		d.Content()
 	}
 
-	return &candidate{s, float32(contentScore)}
+	breakdowns := make(map[*html.Node]ScoreBreakdown)
+	for node, c := range d.candidates {
+		breakdowns[node] = c.breakdown
+	}
+
+	return breakdowns
 }
 
 func (d *Document) sanitize(article string) string {
@@ -361,17 +3858,81 @@ func (d *Document) sanitize(article string) string {
 		return ""
 	}
 
-	s := doc.Find("body")
-	s.Find("h1,h2,h3,h4,h5,h6").Each(func(i int, header *goquery.Selection) {
+	s := doc.Find("body").First()
+	s.Find("h1,h2,h3,h4,h5,h6,summary").Each(func(i int, header *goquery.Selection) {
 		if d.classWeight(header) < 0 || d.getLinkDensity(header) > 0.33 {
 			removeNodes(header)
 		}
 	})
 
+	if d.UseAccessibleText {
+		useAccessibleText(s)
+	}
+
+	if d.MinImageWidth > 0 || d.MinImageHeight > 0 {
+		s.Find("img").Each(func(i int, img *goquery.Selection) {
+			width, hasWidth := imageDimension(img, "width")
+			height, hasHeight := imageDimension(img, "height")
+
+			if (hasWidth && width < d.MinImageWidth) || (hasHeight && height < d.MinImageHeight) {
+				src, _ := img.Attr("src")
+				Logger.Printf("Removing small image %s (%dx%d)\n", src, width, height)
+				removeNodes(img)
+			}
+		})
+	}
+
 	s.Find("input,select,textarea,button,object,iframe,embed").Each(func(i int, s *goquery.Selection) {
 		removeNodes(s)
 	})
 
+	if d.StripImageCredits {
+		removeNodes(s.Find(captionSelector).Find(imageCreditSelector))
+	}
+
+	if d.RemoveTrailingBoilerplate && d.TrailingBoilerplateRegexp != nil {
+		d.removeTrailingBoilerplate(s.Get(0))
+	}
+
+	if d.PreserveFootnotes {
+		d.cleanFootnotes(s)
+	}
+
+	// some editors mark emphasis with inline styles rather than tags
+	if d.InferSemanticsFromStyle {
+		s.Find("span[style]").Each(func(i int, span *goquery.Selection) {
+			inferSemanticsFromStyle(span.Get(0))
+		})
+	}
+
+	// labels and calls to action that slip past the class based heuristics
+	if len(d.JunkPhrases) > 0 {
+		junk := make(map[string]bool, len(d.JunkPhrases))
+		for _, phrase := range d.JunkPhrases {
+			junk[strings.ToLower(strings.Join(strings.Fields(phrase), " "))] = true
+		}
+
+		// only the innermost blocks, so the article around them is kept
+		s.Find("*").FilterFunction(func(i int, el *goquery.Selection) bool {
+			return isLeafBlock(el.Get(0))
+		}).Each(func(i int, el *goquery.Selection) {
+			if text := strings.ToLower(strings.Join(strings.Fields(el.Text()), " ")); junk[text] {
+				Logger.Printf("Removing junk %s %q\n", el.Get(0).Data, text)
+				removeNodes(el)
+			}
+		})
+	}
+
+	// separators such as "• • •" or a row of emoji
+	if d.StripDecorativeParagraphs {
+		s.Find("p").Each(func(i int, p *goquery.Selection) {
+			if isDecorative(p.Text()) && p.Find("img").Length() == 0 {
+				Logger.Printf("Removing decorative paragraph %q\n", strings.TrimSpace(p.Text()))
+				removeNodes(p)
+			}
+		})
+	}
+
 	if d.RemoveEmptyNodes {
 		s.Find("p").Each(func(i int, s *goquery.Selection) {
 			html, _ := s.Html()
@@ -381,7 +3942,11 @@ func (d *Document) sanitize(article string) string {
 		})
 	}
 
-	d.cleanConditionally(s, "table,ul,div")
+	if d.TableLayoutMode {
+		d.cleanConditionally(s, "ul,div")
+	} else {
+		d.cleanConditionally(s, "table,ul,div")
+	}
 
 	// we'll sanitize all elements using a whitelist
 	replaceWithWhitespace := map[string]bool{
@@ -393,6 +3958,7 @@ func (d *Document) sanitize(article string) string {
 		"h4":         true,
 		"h5":         true,
 		"h6":         true,
+		"summary":    true,
 		"dl":         true,
 		"dd":         true,
 		"ol":         true,
@@ -410,6 +3976,43 @@ func (d *Document) sanitize(article string) string {
 		delete(replaceWithWhitespace, tag)
 	}
 
+	// <hr> often separates sections of long-form articles
+	if d.PreserveHorizontalRules {
+		whitelist["hr"] = true
+		delete(replaceWithWhitespace, "hr")
+	}
+
+	// formulas, exponents and footnote markers change meaning when flattened
+	if d.PreserveScientificMarkup {
+		whitelist["sup"] = true
+		whitelist["sub"] = true
+	}
+
+	if d.PreserveFootnotes {
+		whitelist["sup"] = true
+	}
+
+	// highlighted text is often the answer in quizzes and study guides
+	if d.PreserveHighlights {
+		whitelist["mark"] = true
+	}
+
+	// a flattened table's caption would otherwise run into its first cell
+	if d.KeepTableCaptions && !whitelist["table"] {
+		s.Find("table > caption").Each(func(i int, caption *goquery.Selection) {
+			if text := strings.Join(strings.Fields(caption.Text()), " "); text != "" {
+				caption.Parent().BeforeHtml("<p>" + html.EscapeString(text) + "</p>")
+			}
+			removeNodes(caption)
+		})
+	}
+
+	// ids linked to from within the article
+	targets := make(map[string]bool)
+	if d.PreserveAnchors {
+		targets = anchorTargets(s)
+	}
+
 	var text string
 
 	s.Find("*").Each(func(i int, s *goquery.Selection) {
@@ -423,9 +4026,40 @@ func (d *Document) sanitize(article string) string {
 			return
 		}
 
-		// if element is in whitelist, delete all its attributes
-		if _, ok := whitelist[node.Data]; ok {
-			node.Attr = make([]html.Attribute, 0)
+		// spans carrying language, direction or a role change the meaning
+		// of their text, so keep them along with those attributes
+		if semanticAttr := filterAttributes(node.Attr, "role", "lang", "dir"); d.PreserveSemanticSpans && node.Data == "span" && len(semanticAttr) > 0 {
+			node.Attr = semanticAttr
+		} else if d.PreserveTimeElements && node.Data == "time" {
+			// keep dates machine readable
+			node.Attr = filterAttributes(node.Attr, "datetime")
+		} else if d.PreserveMath && node.Namespace == "math" {
+			// MathML is meaningless once flattened
+			node.Attr = filterAttributes(node.Attr, mathAttributes...)
+		} else if d.PreserveAbbreviations && node.Data == "abbr" {
+			// the expansion is what screen readers announce
+			node.Attr = filterAttributes(node.Attr, "title")
+		} else if _, ok := whitelist[node.Data]; ok {
+			// if element is in whitelist, delete all its attributes other
+			// than those for links. In-page links are only kept along with
+			// their targets, see anchorAttributes.
+			attrs := anchorAttributes(node.Attr, targets)
+			if href, ok := s.Attr("href"); ok && node.Data == "a" && !strings.HasPrefix(href, "#") {
+				attrs = append(attrs, html.Attribute{Key: "href", Val: href})
+			}
+			if d.PreserveDirection {
+				attrs = append(attrs, filterAttributes(node.Attr, "dir")...)
+			}
+			if d.StripTrackingParams {
+				// so that links can be shared without whoever shares
+				// them being tracked
+				for i := range attrs {
+					if attrs[i].Key == "href" {
+						attrs[i].Val = stripTrackingParams(attrs[i].Val, d.TrackingParams)
+					}
+				}
+			}
+			node.Attr = attrs
 		} else {
 			if _, ok := replaceWithWhitespace[node.Data]; ok {
 				// just replace with a text node and add whitespace
@@ -446,19 +4080,540 @@ func (d *Document) sanitize(article string) string {
 	})
 
 	if text == "" {
+		tidyNodes(s.Get(0), d.RemoveEmptyElements)
+
+		if d.MergeShortParagraphs {
+			mergeShortParagraphs(s, d.ShortParagraphLength)
+		}
+
+		normalizeWhitespace(doc.Get(0))
+		if d.PreserveNonBreakingSpaces {
+			collapseNonBreakingSpaces(doc.Get(0))
+		}
 		text, _ = doc.Html()
+		if d.CompactWhitespace {
+			compactWhitespace(doc.Get(0))
+			text, _ = doc.Html()
+			text = strings.TrimSpace(text)
+		}
+
+		return text
 	}
 
 	return normalizeWhitespaceRegexp.ReplaceAllString(text, "\n")
 }
 
+// voidTags are the elements that never have an end tag.
+var voidTags = map[string]bool{
+	"area":   true,
+	"base":   true,
+	"br":     true,
+	"col":    true,
+	"embed":  true,
+	"hr":     true,
+	"img":    true,
+	"input":  true,
+	"link":   true,
+	"meta":   true,
+	"param":  true,
+	"source": true,
+	"track":  true,
+	"wbr":    true,
+}
+
+// mediaTags are the elements that are content without having any text.
+var mediaTags = map[string]bool{
+	"audio":   true,
+	"br":      true,
+	"canvas":  true,
+	"embed":   true,
+	"hr":      true,
+	"iframe":  true,
+	"img":     true,
+	"math":    true,
+	"object":  true,
+	"picture": true,
+	"source":  true,
+	"svg":     true,
+	"video":   true,
+	"wbr":     true,
+}
+
+// structuralTags are the elements whose position matters even when they are
+// empty, such as the cells that keep the rest of a row in the right columns.
+var structuralTags = map[string]bool{
+	"col":      true,
+	"colgroup": true,
+	"dd":       true,
+	"dt":       true,
+	"li":       true,
+	"tbody":    true,
+	"td":       true,
+	"tfoot":    true,
+	"th":       true,
+	"thead":    true,
+	"tr":       true,
+}
+
+// tidyNodes cleans up after flattening the elements within n. An element
+// whose only content is an identical element, such as <p><p>...</p></p>, is
+// merged with it, and when removeEmpty is set, elements without any text or
+// media are removed, other than structural ones.
+func tidyNodes(n *html.Node, removeEmpty bool) {
+	var next *html.Node
+	for c := n.FirstChild; c != nil; c = next {
+		next = c.NextSibling
+
+		// MathML uses empty elements such as <mspace> for layout
+		if c.Type != html.ElementNode || c.Namespace == "math" {
+			continue
+		}
+
+		tidyNodes(c, removeEmpty)
+
+		if removeEmpty && !structuralTags[c.Data] && isEmptyNode(c) {
+			n.RemoveChild(c)
+			continue
+		}
+
+		if only := onlyChildElement(c); only != nil && only.Data == c.Data && reflect.DeepEqual(only.Attr, c.Attr) {
+			replaceNodeWithChildren(only)
+		}
+	}
+}
+
+// isEmptyNode returns whether n has neither text nor media within it.
+func isEmptyNode(n *html.Node) bool {
+	if mediaTags[n.Data] {
+		return false
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			if strings.TrimSpace(c.Data) != "" {
+				return false
+			}
+		case html.ElementNode:
+			if !isEmptyNode(c) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// onlyChildElement returns the single element within n if there is nothing
+// else in it but whitespace, or nil otherwise.
+func onlyChildElement(n *html.Node) *html.Node {
+	var only *html.Node
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.ElementNode:
+			if only != nil {
+				return nil
+			}
+			only = c
+		case html.TextNode:
+			if strings.TrimSpace(c.Data) != "" {
+				return nil
+			}
+		default:
+			return nil
+		}
+	}
+
+	return only
+}
+
+// preformattedTags are the elements whose whitespace is significant.
+var preformattedTags = map[string]bool{
+	"code":     true,
+	"pre":      true,
+	"textarea": true,
+}
+
+// normalizeWhitespace collapses runs of line breaks in the text within n,
+// except inside preformatted elements where they may be part of the content,
+// such as the blank lines and indentation of code.
+func normalizeWhitespace(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			c.Data = normalizeWhitespaceRegexp.ReplaceAllString(c.Data, "\n")
+		case html.ElementNode:
+			if !preformattedTags[c.Data] {
+				normalizeWhitespace(c)
+			}
+		default:
+			normalizeWhitespace(c)
+		}
+	}
+}
+
+// collapseNonBreakingSpaces replaces runs of whitespace that include a
+// non-breaking space with a single space, as such runs are usually there for
+// layout. Lone non-breaking spaces, such as the one keeping "10 km" together,
+// are kept.
+func collapseNonBreakingSpaces(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			c.Data = spaceRunRegexp.ReplaceAllStringFunc(c.Data, func(run string) string {
+				if strings.ContainsRune(run, '\u00a0') {
+					return " "
+				}
+				return run
+			})
+		case html.ElementNode:
+			if !preformattedTags[c.Data] {
+				collapseNonBreakingSpaces(c)
+			}
+		default:
+			collapseNonBreakingSpaces(c)
+		}
+	}
+}
+
+// compactWhitespace drops whitespace-only text between block level elements
+// and at the start and end of them, and collapses the remaining whitespace
+// around line breaks so no blank lines are left in the output.
+func compactWhitespace(n *html.Node) {
+	var next *html.Node
+	for c := n.FirstChild; c != nil; c = next {
+		next = c.NextSibling
+
+		switch c.Type {
+		case html.TextNode:
+			// merge runs of text left behind by removed elements
+			for next != nil && next.Type == html.TextNode {
+				c.Data += next.Data
+				n.RemoveChild(next)
+				next = c.NextSibling
+			}
+
+			if strings.TrimSpace(c.Data) == "" && isBlockBoundary(c.PrevSibling) && isBlockBoundary(c.NextSibling) {
+				n.RemoveChild(c)
+			} else {
+				c.Data = blankLinesRegexp.ReplaceAllString(c.Data, "\n")
+			}
+		case html.ElementNode:
+			if !preformattedTags[c.Data] {
+				compactWhitespace(c)
+			}
+		default:
+			compactWhitespace(c)
+		}
+	}
+}
+
+// isBlockBoundary reports whether whitespace next to n can be dropped, that
+// is n is a block level element or there is no sibling at all.
+func isBlockBoundary(n *html.Node) bool {
+	return n == nil || (n.Type == html.ElementNode && blockTags[n.Data])
+}
+
+// cleanFootnotes turns footnote reference links into <sup> markers and drops
+// the links from each note back to its reference.
+func (d *Document) cleanFootnotes(s *goquery.Selection) {
+	s.Find("a[href]").Each(func(i int, a *goquery.Selection) {
+		href, _ := a.Attr("href")
+		node := a.Get(0)
+
+		if footnoteBackrefRegexp.MatchString(href) || a.HasClass("footnote-backref") {
+			removeNodes(a)
+		} else if footnoteRefRegexp.MatchString(href) {
+			if node.Parent != nil && node.Parent.Type == html.ElementNode && node.Parent.Data == "sup" {
+				replaceNodeWithChildren(node)
+			} else {
+				node.Data = "sup"
+				node.Attr = nil
+			}
+		}
+	})
+}
+
+// imageDimension returns the width or height in pixels declared for img,
+// either as an attribute or in its style, and whether one was declared.
+func imageDimension(img *goquery.Selection, name string) (int, bool) {
+	values := make([]string, 0)
+	if value, ok := img.Attr(name); ok {
+		values = append(values, value)
+	}
+
+	style, _ := img.Attr("style")
+	for _, declaration := range strings.Split(style, ";") {
+		if parts := strings.SplitN(declaration, ":", 2); len(parts) == 2 && strings.TrimSpace(strings.ToLower(parts[0])) == name {
+			values = append(values, parts[1])
+		}
+	}
+
+	for _, value := range values {
+		if m := imageDimensionRegexp.FindStringSubmatch(value); m != nil {
+			if n, err := strconv.Atoi(m[1]); err == nil {
+				return n, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// isDecorative returns whether text has something other than whitespace, but
+// no letters or digits, leaving only punctuation, symbols and emoji.
+func isDecorative(text string) bool {
+	if strings.TrimSpace(text) == "" {
+		return false
+	}
+
+	return strings.IndexFunc(text, func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsNumber(r)
+	}) < 0
+}
+
+// useAccessibleText gives icon-only links and buttons within s their
+// aria-label or title as text. Labelled buttons are replaced by their label,
+// as buttons are otherwise removed.
+func useAccessibleText(s *goquery.Selection) {
+	s.Find("a,button").Each(func(i int, el *goquery.Selection) {
+		if strings.TrimSpace(el.Text()) != "" {
+			return
+		}
+
+		label, _ := el.Attr("aria-label")
+		if strings.TrimSpace(label) == "" {
+			label, _ = el.Attr("title")
+		}
+
+		label = strings.TrimSpace(label)
+		if label == "" {
+			return
+		}
+
+		node := el.Get(0)
+		text := &html.Node{Type: html.TextNode, Data: label}
+
+		if el.Is("button") {
+			node.Parent.InsertBefore(text, node)
+			node.Parent.RemoveChild(node)
+		} else {
+			node.AppendChild(text)
+		}
+	})
+}
+
+// anchorTargets returns the ids of the elements within s that are linked to
+// from within s. Targets marked with <a name> are given to the element the
+// anchor is in, or the one following it, so they survive the anchor being
+// flattened.
+func anchorTargets(s *goquery.Selection) map[string]bool {
+	linked := make(map[string]bool)
+	s.Find(`a[href^="#"]`).Each(func(i int, a *goquery.Selection) {
+		href, _ := a.Attr("href")
+		if fragment := strings.TrimPrefix(href, "#"); fragment != "" {
+			linked[fragment] = true
+		}
+	})
+
+	targets := make(map[string]bool)
+	s.Find("[id]").Each(func(i int, el *goquery.Selection) {
+		if id, _ := el.Attr("id"); linked[id] {
+			targets[id] = true
+		}
+	})
+
+	s.Find("a[name]").Each(func(i int, a *goquery.Selection) {
+		name, _ := a.Attr("name")
+		if !linked[name] || targets[name] {
+			return
+		}
+
+		targets[name] = true
+
+		// an empty anchor usually sits just before the heading it marks
+		node := a.Get(0)
+		host := node.Parent
+		if next := nextElementSibling(node); next != nil && strings.TrimSpace(a.Text()) == "" {
+			host = next
+		}
+
+		if _, ok := goquery.NewDocumentFromNode(host).Attr("id"); ok || host == s.Get(0) {
+			a.SetAttr("id", name)
+		} else {
+			host.Attr = append(host.Attr, html.Attribute{Key: "id", Val: name})
+		}
+	})
+
+	return targets
+}
+
+// anchorAttributes returns the ids in attrs that are targets, and hrefs that
+// link to them.
+func anchorAttributes(attrs []html.Attribute, targets map[string]bool) []html.Attribute {
+	filtered := make([]html.Attribute, 0)
+	for _, attr := range attrs {
+		if (attr.Key == "id" && targets[attr.Val]) || (attr.Key == "href" && strings.HasPrefix(attr.Val, "#") && targets[attr.Val[1:]]) {
+			filtered = append(filtered, attr)
+		}
+	}
+
+	return filtered
+}
+
+// stripTrackingParams removes the query parameters named in params from
+// link, keeping the order of the rest. A name ending in * matches any
+// parameter starting with it.
+func stripTrackingParams(link string, params []string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.RawQuery == "" {
+		return link
+	}
+
+	kept := make([]string, 0)
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		name := strings.SplitN(pair, "=", 2)[0]
+		if name, err := url.QueryUnescape(name); err != nil || !isTrackingParam(name, params) {
+			kept = append(kept, pair)
+		}
+	}
+
+	u.RawQuery = strings.Join(kept, "&")
+	return u.String()
+}
+
+func isTrackingParam(name string, params []string) bool {
+	for _, param := range params {
+		if strings.HasSuffix(param, "*") && strings.HasPrefix(name, strings.TrimSuffix(param, "*")) {
+			return true
+		} else if name == param {
+			return true
+		}
+	}
+
+	return false
+}
+
+// removeTrailingBoilerplate removes the author bios, share buttons and tag
+// lists that follow the last of the article's content. It works back from the
+// end of n, descending into the last remaining block until it reaches
+// content.
+func (d *Document) removeTrailingBoilerplate(n *html.Node) {
+	for c := n.LastChild; c != nil; {
+		prev := c.PrevSibling
+
+		if c.Type == html.TextNode && strings.TrimSpace(c.Data) == "" {
+			c = prev
+			continue
+		}
+
+		if c.Type != html.ElementNode {
+			return
+		}
+
+		s := goquery.NewDocumentFromNode(c).Selection
+		class, _ := s.Attr("class")
+		id, _ := s.Attr("id")
+
+		if !d.TrailingBoilerplateRegexp.MatchString(class + " " + id) {
+			if c.Data != "p" {
+				d.removeTrailingBoilerplate(c)
+			}
+			return
+		}
+
+		Logger.Printf("Removing trailing boilerplate %s%s\n", c.Data, getName(s))
+		n.RemoveChild(c)
+		c = prev
+	}
+}
+
+// mergeShortParagraphs joins runs of adjacent <p>s shorter than minLength, as
+// emitted by CMSes that put every sentence in its own paragraph, into a single
+// paragraph.
+func mergeShortParagraphs(s *goquery.Selection, minLength int) {
+	s.Find("p").Each(func(i int, p *goquery.Selection) {
+		node := p.Get(0)
+
+		// already merged into a previous paragraph
+		if node.Parent == nil {
+			return
+		}
+
+		length := len(strings.TrimSpace(p.Text()))
+
+		for length < minLength {
+			next := nextElementSibling(node)
+			if next == nil || next.Data != "p" {
+				return
+			}
+
+			nextLength := len(strings.TrimSpace(goquery.NewDocumentFromNode(next).Text()))
+			if nextLength >= minLength {
+				return
+			}
+
+			// drop the whitespace between the paragraphs and keep the
+			// sentences apart with a single space
+			for c := node.NextSibling; c != next; c = node.NextSibling {
+				node.Parent.RemoveChild(c)
+			}
+			node.AppendChild(&html.Node{Type: html.TextNode, Data: " "})
+
+			for c := next.FirstChild; c != nil; c = next.FirstChild {
+				next.RemoveChild(c)
+				node.AppendChild(c)
+			}
+			next.Parent.RemoveChild(next)
+
+			length = nextLength
+		}
+	})
+}
+
+// nextElementSibling returns the element following n, or nil if there is
+// anything other than whitespace in between.
+func nextElementSibling(n *html.Node) *html.Node {
+	for c := n.NextSibling; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.ElementNode:
+			return c
+		case html.TextNode:
+			if strings.TrimSpace(c.Data) != "" {
+				return nil
+			}
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
+
 func (d *Document) cleanConditionally(s *goquery.Selection, selector string) {
 	if !d.CleanConditionally {
 		return
 	}
 
+	var lead *html.Node
+	if d.BestCandidateHasImage {
+		lead = leadImage(s)
+	}
+
 	s.Find(selector).Each(func(i int, s *goquery.Selection) {
 		node := s.Get(0)
+
+		if lead != nil && leadImage(s) == lead && d.isHeroImageWrapper(s) {
+			Logger.Printf("Keeping lead image wrapper %s%s\n", node.Data, getName(s))
+			return
+		}
+
+		if d.PreserveFootnotes && s.Closest(footnotesSelector).Length() > 0 {
+			Logger.Printf("Keeping footnotes %s%s\n", node.Data, getName(s))
+			return
+		}
+
 		weight := float32(d.classWeight(s))
 		contentScore := float32(0)
 
@@ -519,6 +4674,21 @@ func (d *Document) cleanConditionally(s *goquery.Selection, selector string) {
 	})
 }
 
+// filterAttributes returns the attributes in attrs with one of the given keys.
+func filterAttributes(attrs []html.Attribute, keys ...string) []html.Attribute {
+	filtered := make([]html.Attribute, 0)
+	for _, attr := range attrs {
+		for _, key := range keys {
+			if attr.Key == key {
+				filtered = append(filtered, attr)
+				break
+			}
+		}
+	}
+
+	return filtered
+}
+
 func getName(s *goquery.Selection) string {
 	class, _ := s.Attr("class")
 	id, _ := s.Attr("id")
@@ -1,9 +1,25 @@
 package readability
 
 import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
 )
 
 type expectedOutput struct {
@@ -126,6 +142,82 @@ func TestOutputForWellKnownDocuments(t *testing.T) {
 				"Latest videos",
 			},
 		},
+		"template_content.html": &expectedOutput{
+			requiredFragments: []string{
+				"For more than a century, the lighthouse at the end of the northern spit was tended by a single family",
+				"Automation arrived in the nineteen-nineties",
+			},
+			excludedFragments: []string{
+				"This template text is never rendered",
+				"Another inert template paragraph",
+				"Declarative shadow root text",
+			},
+		},
+		"comment_in_code.html": &expectedOutput{
+			requiredFragments: []string{
+				"Before feature detection became common, the usual way to target old versions of Internet Explorer was the conditional comment",
+				"&lt;!--[if lt IE 9]&gt;",
+				"&lt;![endif]--&gt;",
+				"Modern versions of the browser dropped support entirely",
+			},
+			excludedFragments: []string{
+				"site navigation",
+				"end of post",
+			},
+		},
+		"details_faq.html": &expectedOutput{
+			requiredFragments: []string{
+				"Composting at home turns kitchen scraps and garden waste into a rich soil improver",
+				"How long does compost take to be ready?",
+				"A well-managed heap, turned every few weeks, can be ready in three to six months",
+				"Can I compost cooked food?",
+				"It is best to leave cooked food, meat, and dairy out of an open heap",
+			},
+			excludedFragments: []string{
+				"<details",
+				"<summary",
+			},
+		},
+		"semantic_article.html": &expectedOutput{
+			requiredFragments: []string{
+				"For almost forty years the clock on the market hall told the wrong time",
+				"The clock started again on New Year's Day",
+			},
+			excludedFragments: []string{
+				"Other news this week",
+				"the town twinning association is looking for host families",
+			},
+		},
+		"iframe_srcdoc.html": &expectedOutput{
+			requiredFragments: []string{
+				"He kept the light on the island for twenty-two years",
+				"he still walks to the end of the harbour wall every evening",
+			},
+			excludedFragments: []string{
+				"<iframe",
+				"srcdoc",
+			},
+		},
+		"cjk_sentences.html": &expectedOutput{
+			requiredFragments: []string{
+				"駅前の商店街に、小さな本屋が開店した。",
+				"営業は毎日十時から。",
+				"定休日は水曜日です。",
+			},
+			excludedFragments: []string{
+				"ホーム",
+			},
+		},
+		"single_paragraph.html": &expectedOutput{
+			requiredFragments: []string{
+				"It started, as these things often do, with a letter.",
+				"the bench by the gate has a small brass plate with her name on it.",
+			},
+			excludedFragments: []string{
+				"Writing",
+				"Posted in Family",
+			},
+		},
 	}
 
 	for file, expectedOutput := range inputs {
@@ -164,3 +256,3453 @@ func normalizeString(s string) string {
 
 	return s
 }
+
+func TestPreserveHorizontalRules(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/horizontal_rules.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/horizontal_rules.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+	if strings.Contains(content, "<hr") {
+		t.Errorf("Did not expect content %q to contain %q", content, "<hr")
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.PreserveHorizontalRules = true
+	content = doc.Content()
+	if count := strings.Count(content, "<hr/>"); count != 2 {
+		t.Errorf("Expected content %q to contain 2 horizontal rules, got %d", content, count)
+	}
+
+	if text := doc.Text(); strings.Count(text, "\n\n---\n\n") != 2 {
+		t.Errorf("Expected text %q to contain 2 horizontal rules", text)
+	}
+}
+
+func TestContentWithOptions(t *testing.T) {
+	html := `<html><head><title>title!</title></head><body><div><p>Some <b>bold</b> content</p></div><div class='sidebar'><p>sidebar</p></div></body>`
+	doc, err := NewDocument(html)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.MinTextLength = 0
+	doc.RetryLength = 1
+
+	content, err := doc.ContentWithOptions(func(d *Document) {
+		d.WhitelistTags = append(d.WhitelistTags, "b")
+	})
+	if err != nil {
+		t.Fatal("Unable to extract content", err)
+	}
+	if !strings.Contains(content, "<b>bold</b>") {
+		t.Errorf("Expected content %q to contain %q", content, "<b>bold</b>")
+	}
+
+	content, err = doc.ContentWithOptions(func(d *Document) {
+		d.RemoveUnlikelyCandidates = false
+	})
+	if err != nil {
+		t.Fatal("Unable to extract content", err)
+	}
+	if strings.Contains(content, "<b>") {
+		t.Errorf("Did not expect content %q to contain %q", content, "<b>")
+	}
+
+	if len(doc.WhitelistTags) != 2 || !doc.RemoveUnlikelyCandidates {
+		t.Errorf("Expected document options to be left untouched, got %v and %v", doc.WhitelistTags, doc.RemoveUnlikelyCandidates)
+	}
+
+	content = doc.Content()
+	if strings.Contains(content, "<b>") || strings.Contains(content, "sidebar") {
+		t.Errorf("Did not expect content %q to contain options from previous calls", content)
+	}
+}
+
+func TestNewDocumentFromGoquery(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/sibling_order.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/sibling_order.html", err)
+	}
+
+	parsed, err := goquery.NewDocumentFromReader(strings.NewReader(string(bytes)))
+	if err != nil {
+		t.Fatal("Unable to parse document", err)
+	}
+
+	doc, err := NewDocumentFromGoquery(parsed)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	expected, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if content := doc.Content(); content != expected.Content() {
+		t.Errorf("Expected content %q to be %q", content, expected.Content())
+	}
+
+	if title := doc.Title(); title != "The long way round" {
+		t.Errorf("Expected title %q to be %q", title, "The long way round")
+	}
+
+	// retries start again from the page as it was before extraction
+	parsed, err = goquery.NewDocumentFromReader(strings.NewReader(`<html><body><div class="sidebar"><p>Some content, but short.</p></div></body></html>`))
+	if err != nil {
+		t.Fatal("Unable to parse document", err)
+	}
+
+	doc, err = NewDocumentFromGoquery(parsed)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if content := doc.Content(); !strings.Contains(content, "Some content, but short.") {
+		t.Errorf("Expected content %q to contain the unlikely candidate after retrying", content)
+	}
+
+	if _, err := NewDocumentFromGoquery(nil); err == nil {
+		t.Errorf("Expected an error creating a document from nil")
+	}
+}
+
+func TestTitle(t *testing.T) {
+	html := `<html><head><title> title! </title></head><body><div><h1>Heading</h1><p>Some content</p></div></body>`
+	doc, err := NewDocument(html)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if title := doc.Title(); title != "title!" {
+		t.Errorf("Expected title %q to be %q", title, "title!")
+	}
+}
+
+func TestTitleStripsSiteName(t *testing.T) {
+	inputs := map[string]string{
+		`<html><head><title>Council approves footbridge - The Riverside Gazette</title></head><body><div><h1>Council approves footbridge</h1><p>Some content</p></div></body>`: "Council approves footbridge",
+		`<html><head><title>Council approves footbridge | Gazette</title></head><body><div><h1> Council approves
+			footbridge </h1><p>Some content</p></div></body>`: "Council approves footbridge",
+		// a heading that only matches part of a word
+		`<html><head><title>Councillors approve footbridge</title></head><body><div><h1>Council</h1><p>Some content</p></div></body>`: "Councillors approve footbridge",
+		// a heading that isn't the start of the title
+		`<html><head><title>The Riverside Gazette: Council approves footbridge</title></head><body><div><h1>Council approves footbridge</h1><p>Some content</p></div></body>`: "The Riverside Gazette: Council approves footbridge",
+		`<html><head><title>Council approves footbridge</title></head><body><div><h1>Council approves footbridge</h1><p>Some content</p></div></body>`:                        "Council approves footbridge",
+	}
+
+	for input, expected := range inputs {
+		doc, err := NewDocument(input)
+		if err != nil {
+			t.Fatal("Unable to create document", err)
+		}
+
+		if title := doc.Title(); title != expected {
+			t.Errorf("Expected title %q to be %q", title, expected)
+		}
+	}
+}
+
+func TestTitleFallsBackToHeading(t *testing.T) {
+	html := `<html><head><title></title></head><body><div><h2 class="sidebar">Related</h2><h2>Subheading</h2><h1>Heading</h1><p>Some content</p></div></body>`
+	doc, err := NewDocument(html)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.MinTextLength = 0
+	doc.RetryLength = 1
+
+	if title := doc.Title(); title != "Heading" {
+		t.Errorf("Expected title %q to be %q", title, "Heading")
+	}
+}
+
+func TestHasPaywall(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/paywall.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/paywall.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.Content()
+	if !doc.HasPaywall() {
+		t.Errorf("Expected document to have a paywall")
+	}
+
+	inputs := map[string]bool{
+		`<html><head><title>title!</title></head><body><div><p>Some content</p></div></body>`:                                                         false,
+		`<html><head><meta name="robots" content="index, max-snippet:50"></head><body><p>Some content</p></body>`:                                     true,
+		`<html><head><meta name="robots" content="index, max-snippet:0"></head><body><p>Some content</p></body>`:                                      true,
+		`<html><head><meta name="robots" content="index, follow, max-snippet:-1"></head><body><p>Some content</p></body>`:                             false,
+		`<html><body><p>Some content</p><div id="metered-banner"></div></body>`:                                                                       true,
+		`<html><head><script type="application/ld+json">{"@graph":[{"isAccessibleForFree":"False"}]}</script></head><body><p>Some content</p></body>`: true,
+		`<html><head><script type="application/ld+json">{"isAccessibleForFree":true}</script></head><body><p>Some content</p></body>`:                 false,
+	}
+
+	for input, expected := range inputs {
+		doc, err := NewDocument(input)
+		if err != nil {
+			t.Fatal("Unable to create document", err)
+		}
+
+		if paywall := doc.HasPaywall(); paywall != expected {
+			t.Errorf("Expected paywall for %q to be %v, got %v", input, expected, paywall)
+		}
+	}
+}
+
+func TestMergeShortParagraphs(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/fragmented_paragraphs.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/fragmented_paragraphs.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.RetryLength = 1
+	content := doc.Content()
+	if count := strings.Count(content, "<p>"); count != 6 {
+		t.Errorf("Expected content %q to contain 6 paragraphs, got %d", content, count)
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.RetryLength = 1
+	doc.MergeShortParagraphs = true
+	content = doc.Content()
+	if count := strings.Count(content, "<p>"); count != 3 {
+		t.Errorf("Expected content %q to contain 3 paragraphs, got %d", content, count)
+	}
+
+	expected := "<p>The council has approved a new network of cycle lanes. Work will start in the spring. It is expected to take two years.</p>"
+	if !strings.Contains(content, expected) {
+		t.Errorf("Expected content %q to contain %q", content, expected)
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.RetryLength = 1
+	doc.MergeShortParagraphs = true
+	doc.ShortParagraphLength = 40
+	content = doc.Content()
+	if count := strings.Count(content, "<p>"); count != 5 {
+		t.Errorf("Expected content %q to contain 5 paragraphs, got %d", content, count)
+	}
+
+	expected = "<p>Work will start in the spring. It is expected to take two years.</p>"
+	if !strings.Contains(content, expected) {
+		t.Errorf("Expected content %q to contain %q", content, expected)
+	}
+}
+
+func TestNonContentTags(t *testing.T) {
+	html := `<html><head><title>title!</title></head><body><div><p>Some content<svg><text>chart label</text></svg></p></div></body>`
+	doc, err := NewDocument(html)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.MinTextLength = 0
+	doc.RetryLength = 1
+
+	content := doc.Content()
+	if !strings.Contains(content, "chart label") {
+		t.Errorf("Expected content %q to contain %q", content, "chart label")
+	}
+
+	doc, err = NewDocument(html)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.MinTextLength = 0
+	doc.RetryLength = 1
+	doc.NonContentTags = append(doc.NonContentTags, "svg")
+
+	content = doc.Content()
+	if !strings.Contains(content, "Some content") {
+		t.Errorf("Expected content %q to contain %q", content, "Some content")
+	}
+	if strings.Contains(content, "chart label") {
+		t.Errorf("Did not expect content %q to contain %q", content, "chart label")
+	}
+}
+
+func TestSection(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/article_section.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/article_section.html", err)
+	}
+
+	inputs := map[string]string{
+		string(bytes): "Sports",
+		`<html><body><nav class="breadcrumb"><a href="/">Home</a> <a href="/sport">Sport</a></nav><p>Some content</p></body></html>`: "Sport",
+		`<html><head><title>title!</title></head><body><div><p>Some content</p></div></body>`:                                        "",
+	}
+
+	for input, expected := range inputs {
+		doc, err := NewDocument(input)
+		if err != nil {
+			t.Fatal("Unable to create document", err)
+		}
+
+		doc.Content()
+		if section := doc.Section(); section != expected {
+			t.Errorf("Expected section %q to be %q", section, expected)
+		}
+	}
+}
+
+func TestHeroImage(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/hero_image.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/hero_image.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.WhitelistTags = append(doc.WhitelistTags, "img")
+	content := doc.Content()
+
+	if !doc.BestCandidateHasImage {
+		t.Errorf("Expected best candidate to have an image")
+	}
+	if count := strings.Count(content, "<img/>"); count != 2 {
+		t.Errorf("Expected content %q to contain 2 images, got %d", content, count)
+	}
+	if strings.Index(content, "<img/>") > strings.Index(content, "The coast path") {
+		t.Errorf("Expected content %q to start with the hero image", content)
+	}
+}
+
+func TestOnPhase(t *testing.T) {
+	html := `<html><head><title>title!</title></head><body><div><p>Some content</p></div></body>`
+	doc, err := NewDocument(html)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.MinTextLength = 0
+	doc.RetryLength = 1
+
+	phases := make(map[string]int)
+	_, err = doc.ContentWithOptions(func(d *Document) {
+		d.OnPhase = func(phase string, dur time.Duration) {
+			phases[phase]++
+		}
+	})
+	if err != nil {
+		t.Fatal("Unable to extract content", err)
+	}
+
+	for _, phase := range []string{"parse", "removeUnlikely", "scoreParagraphs", "selectBest", "sanitize"} {
+		if phases[phase] != 1 {
+			t.Errorf("Expected phase %q to be reported once, got %d", phase, phases[phase])
+		}
+	}
+
+	// set when creating the document, parsing is reported without having to
+	// parse the page again
+	phases = make(map[string]int)
+	doc, err = NewDocument(html, func(d *Document) {
+		d.MinTextLength = 0
+		d.RetryLength = 1
+		d.OnPhase = func(phase string, dur time.Duration) {
+			phases[phase]++
+		}
+	})
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.Content()
+
+	for _, phase := range []string{"parse", "removeUnlikely", "scoreParagraphs", "selectBest", "sanitize"} {
+		if phases[phase] != 1 {
+			t.Errorf("Expected phase %q to be reported once after NewDocument, got %d", phase, phases[phase])
+		}
+	}
+}
+
+func TestArticleNodes(t *testing.T) {
+	html := `<html><head><title>title!</title></head><body><div class="post" id="story"><p>Some content</p></div><div class='sidebar'><p>sidebar</p></div></body>`
+	doc, err := NewDocument(html)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.MinTextLength = 0
+	doc.RetryLength = 1
+
+	nodes := doc.ArticleNodes()
+	if len(nodes) != 1 {
+		t.Fatalf("Expected 1 article node, got %d", len(nodes))
+	}
+
+	if nodes[0].Data != "div" || len(nodes[0].Attr) != 2 {
+		t.Errorf("Expected article node to be the original div with its attributes, got %q with %v", nodes[0].Data, nodes[0].Attr)
+	}
+}
+
+func TestMisnestedContent(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/unclosed_tags.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/unclosed_tags.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+	if !doc.RemoveUnlikelyCandidates {
+		t.Errorf("Expected the article to be found without retrying")
+	}
+
+	for _, required := range []string{
+		"When we bought the boat she had not moved for six years",
+		"The second winter was spent on the cabin",
+		"She finally moved under her own power last spring",
+	} {
+		if !strings.Contains(content, required) {
+			t.Errorf("Expected content %q to contain %q", content, required)
+		}
+	}
+
+	for _, excluded := range []string{"Boats", "Lovely story"} {
+		if strings.Contains(content, excluded) {
+			t.Errorf("Did not expect content %q to contain %q", content, excluded)
+		}
+	}
+}
+
+func TestPreserveSemanticSpans(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/semantic_spans.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/semantic_spans.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.RetryLength = 1
+	content := doc.Content()
+	if strings.Contains(content, "<span") {
+		t.Errorf("Did not expect content %q to contain %q", content, "<span")
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.RetryLength = 1
+	doc.PreserveSemanticSpans = true
+	content = doc.Content()
+
+	expected := `<span lang="ja">木の芽雨</span>`
+	if !strings.Contains(content, expected) {
+		t.Errorf("Expected content %q to contain %q", content, expected)
+	}
+	if strings.Count(content, "<span") != 1 {
+		t.Errorf("Expected decorative spans in content %q to be flattened", content)
+	}
+}
+
+func TestImageCredits(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/image_credits.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/image_credits.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	credits := doc.ImageCredits()
+	if len(credits) != 1 || credits[0] != "Photograph: Jane Field" {
+		t.Errorf("Expected credits %q to be %q", credits, "Photograph: Jane Field")
+	}
+
+	content := doc.Content()
+	if !strings.Contains(content, "Photograph: Jane Field") {
+		t.Errorf("Expected content %q to contain %q", content, "Photograph: Jane Field")
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.StripImageCredits = true
+	content = doc.Content()
+	if !strings.Contains(content, "A lizard orchid on the verge near the village.") {
+		t.Errorf("Expected content %q to contain %q", content, "A lizard orchid on the verge near the village.")
+	}
+	if strings.Contains(content, "Photograph: Jane Field") {
+		t.Errorf("Did not expect content %q to contain %q", content, "Photograph: Jane Field")
+	}
+	if !strings.Contains(content, "the county botanical recorder") {
+		t.Errorf("Expected content %q to contain %q", content, "the county botanical recorder")
+	}
+}
+
+func TestTableLayoutMode(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/newsletter.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/newsletter.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.TableLayoutMode = true
+	content := doc.Content()
+
+	if count := strings.Count(content, "<p>"); count != 3 {
+		t.Errorf("Expected content %q to contain 3 paragraphs, got %d", content, count)
+	}
+
+	for _, required := range []string{
+		"Harvest edition",
+		"The apple harvest has been the best for a decade",
+		"the bakery has started its seasonal run of pumpkin loaves",
+	} {
+		if !strings.Contains(content, required) {
+			t.Errorf("Expected content %q to contain %q", content, required)
+		}
+	}
+
+	for _, excluded := range []string{"View this email in your browser", "Unsubscribe"} {
+		if strings.Contains(content, excluded) {
+			t.Errorf("Did not expect content %q to contain %q", content, excluded)
+		}
+	}
+}
+
+func TestRecipe(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/recipe.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/recipe.html", err)
+	}
+
+	microdata := `<html><body><div itemscope itemtype="https://schema.org/Recipe">
+		<h1 itemprop="name">Pancakes</h1>
+		<meta itemprop="cookTime" content="PT15M">
+		<span itemprop="recipeYield">8 pancakes</span>
+		<ul><li itemprop="recipeIngredient">100g flour</li><li itemprop="recipeIngredient">2 eggs</li><li itemprop="recipeIngredient">300ml milk</li></ul>
+		<ol itemprop="recipeInstructions"><li>Whisk everything together.</li><li>Fry in a hot pan.</li></ol>
+	</div></body></html>`
+
+	inputs := map[string]*Recipe{
+		string(bytes): &Recipe{
+			Name:         "Weeknight lentil soup",
+			Ingredients:  []string{"1 onion, chopped", "200g red lentils", "1 litre vegetable stock"},
+			Instructions: []string{"Soften the onion in a little oil.", "Add the lentils and stock and simmer for 25 minutes.", "Blend until smooth and season to taste."},
+			PrepTime:     "PT10M",
+			CookTime:     "PT30M",
+			TotalTime:    "PT40M",
+			Yield:        "4",
+		},
+		microdata: &Recipe{
+			Name:         "Pancakes",
+			Ingredients:  []string{"100g flour", "2 eggs", "300ml milk"},
+			Instructions: []string{"Whisk everything together.", "Fry in a hot pan."},
+			CookTime:     "PT15M",
+			Yield:        "8 pancakes",
+		},
+	}
+
+	for input, expected := range inputs {
+		doc, err := NewDocument(input)
+		if err != nil {
+			t.Fatal("Unable to create document", err)
+		}
+
+		recipe, err := doc.Recipe()
+		if err != nil {
+			t.Fatal("Unable to extract recipe", err)
+		}
+
+		if !reflect.DeepEqual(recipe, expected) {
+			t.Errorf("Expected recipe %+v to be %+v", recipe, expected)
+		}
+	}
+
+	doc, err := NewDocument(`<html><head><title>title!</title></head><body><div><p>Some content</p></div></body>`)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if _, err := doc.Recipe(); err != ErrRecipeNotFound {
+		t.Errorf("Expected error %v, got %v", ErrRecipeNotFound, err)
+	}
+}
+
+func TestSiblingOrder(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/sibling_order.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/sibling_order.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+
+	first := strings.Index(content, "First, the introduction")
+	second := strings.Index(content, "Second, the middle of the story")
+	third := strings.Index(content, "Third, the ending")
+	if first < 0 || second < 0 || third < 0 {
+		t.Fatalf("Expected content %q to contain all three sections", content)
+	}
+
+	if !(first < second && second < third) {
+		t.Errorf("Expected content %q to be in document order", content)
+	}
+}
+
+func TestText(t *testing.T) {
+	html := `<html><head><title>title!</title></head><body><div><p>Some   <b>bold</b>
+		content</p><p>More content</p></div></body>`
+	doc, err := NewDocument(html)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.MinTextLength = 0
+	doc.RetryLength = 1
+
+	expected := "Some bold content\n\nMore content"
+	if text := doc.Text(); text != expected {
+		t.Errorf("Expected text %q to be %q", text, expected)
+	}
+}
+
+func TestRawBodyText(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/page_type_article.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/page_type_article.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes) + "<script>var tracking = true;</script>")
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	raw := doc.RawBodyText()
+	for _, required := range []string{"The Riverside Gazette News Sport", "Plans for a footbridge", "Farmers' market moves to the old cattle yard"} {
+		if !strings.Contains(raw, required) {
+			t.Errorf("Expected raw text %q to contain %q", raw, required)
+		}
+	}
+
+	if strings.Contains(raw, "tracking") || strings.Contains(raw, "  ") || strings.Contains(raw, "\n") {
+		t.Errorf("Expected raw text %q to be normalized without scripts", raw)
+	}
+
+	if text := doc.Text(); len(text) >= len(raw) {
+		t.Errorf("Expected text of length %d to be shorter than raw text of length %d", len(text), len(raw))
+	}
+}
+
+func TestTextNormalizePunctuation(t *testing.T) {
+	html := `<html><body><div><p>“It’s not the end — it’s barely the start…” she said, of the 2019–2020 season.</p></div></body></html>`
+
+	doc, err := NewDocument(html)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.RetryLength = 1
+	expected := "“It’s not the end — it’s barely the start…” she said, of the 2019–2020 season."
+	if text := doc.Text(); text != expected {
+		t.Errorf("Expected text %q to be %q", text, expected)
+	}
+
+	doc, err = NewDocument(html)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.RetryLength = 1
+	doc.NormalizePunctuation = true
+	expected = `"It's not the end -- it's barely the start..." she said, of the 2019-2020 season.`
+	if text := doc.Text(); text != expected {
+		t.Errorf("Expected text %q to be %q", text, expected)
+	}
+}
+
+func TestTextWrapWidth(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/horizontal_rules.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/horizontal_rules.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.WrapWidth = 40
+	text := doc.Text()
+
+	if count := strings.Count(text, "\n\n"); count != 4 {
+		t.Errorf("Expected text %q to contain 5 paragraphs, got %d", text, count+1)
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		if length := utf8.RuneCountInString(line); length > 40 {
+			t.Errorf("Expected line %q to be at most 40 characters, got %d", line, length)
+		}
+	}
+
+	if !strings.HasPrefix(text, "Spring arrived late this year, and the\nfirst job, as always, was clearing the\n") {
+		t.Errorf("Expected text %q to be wrapped on word boundaries", text)
+	}
+}
+
+func TestAuthorImage(t *testing.T) {
+	base, _ := url.Parse("https://example.com/blog/post.html")
+
+	inputs := map[string]string{
+		`<html><head><meta property="article:author" content="https://example.com/authors/jane"></head><body><div class="byline"><img class="avatar" src="/img/jane.jpg"> By <a href="/authors/jane">Jane</a></div><p>Some content</p></body></html>`: "https://example.com/img/jane.jpg",
+		`<html><body><p>By <a rel="author" href="/authors/jane"><img src="jane.png"> Jane</a></p><p>Some content</p></body></html>`:                                                                                                                   "https://example.com/blog/jane.png",
+		`<html><head><meta property="article:author" content="https://cdn.example.com/jane.jpg"></head><body><p>Some content</p></body></html>`:                                                                                                       "https://cdn.example.com/jane.jpg",
+		`<html><body><div class="post"><img class="hero" src="hero.jpg"><span class="author">Jane</span><p>Some content</p></div></body></html>`:                                                                                                      "",
+	}
+
+	for input, expected := range inputs {
+		doc, err := NewDocument(input)
+		if err != nil {
+			t.Fatal("Unable to create document", err)
+		}
+
+		doc.BaseURL = base
+		if image := doc.AuthorImage(); image != expected {
+			t.Errorf("Expected author image %q to be %q", image, expected)
+		}
+	}
+}
+
+func TestDescription(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/description.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/description.html", err)
+	}
+
+	inputs := map[string]string{
+		string(bytes): "It wasn't the river, and it wasn't the Romans: the surprising story behind the town's name.",
+		`<html><head><meta name="description" content="Plain description"><meta name="twitter:description" content="Twitter description"></head><body></body></html>`:       "Plain description",
+		`<html><head><title>No description</title></head><body><div><p>Some content that is long enough to be used as an excerpt, were one wanted.</p></div></body></html>`: "",
+	}
+
+	for input, expected := range inputs {
+		doc, err := NewDocument(input)
+		if err != nil {
+			t.Fatal("Unable to create document", err)
+		}
+
+		if description := doc.Description(); description != expected {
+			t.Errorf("Expected description %q to be %q", description, expected)
+		}
+	}
+}
+
+func TestAlternateLanguages(t *testing.T) {
+	base, _ := url.Parse("https://example.com/en/news/story")
+
+	inputs := map[string]map[string]string{
+		`<html><head><link rel="alternate" hreflang="fr" href="/fr/news/story"><link rel="alternate" hreflang="de-CH" href="https://example.ch/news/story"><link rel="alternate" hreflang="x-default" href="/news/story"><link rel="alternate" type="application/rss+xml" href="/feed"></head><body></body></html>`: map[string]string{
+			"fr":        "https://example.com/fr/news/story",
+			"de-CH":     "https://example.ch/news/story",
+			"x-default": "https://example.com/news/story",
+		},
+		`<html><head><link rel="canonical" href="/en/news/story"></head><body></body></html>`: map[string]string{},
+	}
+
+	for input, expected := range inputs {
+		doc, err := NewDocument(input)
+		if err != nil {
+			t.Fatal("Unable to create document", err)
+		}
+
+		doc.BaseURL = base
+		if languages := doc.AlternateLanguages(); !reflect.DeepEqual(languages, expected) {
+			t.Errorf("Expected alternate languages %v to be %v", languages, expected)
+		}
+	}
+}
+
+func TestScoreBreakdowns(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/sibling_order.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/sibling_order.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	var breakdown *ScoreBreakdown
+	for node, b := range doc.ScoreBreakdowns() {
+		for _, attr := range node.Attr {
+			if attr.Key == "class" && attr.Val == "entry" {
+				b := b
+				breakdown = &b
+			}
+		}
+	}
+
+	if breakdown == nil {
+		t.Fatal("Expected a score breakdown for the entry")
+	}
+
+	expected := ScoreBreakdown{
+		TagScore:              5,
+		ClassWeight:           25,
+		ParagraphBonus:        2,
+		CommaBonus:            11,
+		LengthBonus:           3,
+		LinkDensityMultiplier: 1,
+		Score:                 46,
+	}
+	if *breakdown != expected {
+		t.Errorf("Expected score breakdown %+v to be %+v", *breakdown, expected)
+	}
+
+	// a lone <article> too short to be taken outright gets a bonus
+	paragraph := `<p>The ferry to the island runs twice a day in summer, weather permitting, and once a day in winter, when the crossing can take twice as long.</p>`
+	input := `<html><body><article>` + strings.Repeat(paragraph, 2) + `</article><div>` + strings.Repeat(paragraph, 3) + `</div></body></html>`
+
+	doc, err = NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	found := false
+	for node, b := range doc.ScoreBreakdowns() {
+		if node.Data != "article" {
+			continue
+		}
+		found = true
+
+		if b.ArticleBonus != 50 {
+			t.Errorf("Expected the article's bonus to be 50, got %f", b.ArticleBonus)
+		}
+
+		if score := doc.candidates[node].score; b.Score != score {
+			t.Errorf("Expected the article's breakdown score %f to be its score %f", b.Score, score)
+		}
+
+		formula := (b.TagScore+b.ClassWeight+b.ParagraphBonus+b.CommaBonus+b.LengthBonus)*b.LinkDensityMultiplier + b.ArticleBonus
+		if math.Abs(float64(formula-b.Score)) > 1e-3 {
+			t.Errorf("Expected the article's breakdown %+v to add up to its score", b)
+		}
+	}
+
+	if !found {
+		t.Errorf("Expected a score breakdown for the article")
+	}
+}
+
+func TestDuplicateBody(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/duplicate_body.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/duplicate_body.html", err)
+	}
+
+	for _, minTextLength := range []int{25, 1000} {
+		doc, err := NewDocument(string(bytes))
+		if err != nil {
+			t.Fatal("Unable to create document", err)
+		}
+
+		doc.MinTextLength = minTextLength
+		content := doc.Content()
+
+		for _, required := range []string{
+			"The bakery starts work at ten at night",
+			"Most of the night is spent waiting for dough",
+		} {
+			if count := strings.Count(content, required); count != 1 {
+				t.Errorf("Expected content %q to contain %q once, got %d", content, required, count)
+			}
+		}
+	}
+}
+
+func TestRemoveTrailingBoilerplate(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/trailing_author_bio.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/trailing_author_bio.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+	if !strings.Contains(content, "Sam Rivers is our environment correspondent") {
+		t.Errorf("Expected content %q to contain %q", content, "Sam Rivers is our environment correspondent")
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.RemoveTrailingBoilerplate = true
+	content = doc.Content()
+
+	if !strings.Contains(content, "residents are, understandably, running out of patience") {
+		t.Errorf("Expected content %q to contain %q", content, "residents are, understandably, running out of patience")
+	}
+
+	for _, excluded := range []string{"Filed under", "Sam Rivers is our environment correspondent", "Share this story"} {
+		if strings.Contains(content, excluded) {
+			t.Errorf("Did not expect content %q to contain %q", content, excluded)
+		}
+	}
+}
+
+func TestExtractMany(t *testing.T) {
+	inputs := make([]string, 0)
+	for i := 0; i < 20; i++ {
+		inputs = append(inputs, fmt.Sprintf(`<html><head><title>title %d</title></head><body><div><p>Some content</p></div></body>`, i))
+	}
+
+	results, err := ExtractMany(context.Background(), inputs, 4)
+	if err != nil {
+		t.Fatal("Unable to extract documents", err)
+	}
+
+	if len(results) != len(inputs) {
+		t.Fatalf("Expected %d results, got %d", len(inputs), len(results))
+	}
+
+	for i, result := range results {
+		expected := fmt.Sprintf("title %d", i)
+		if result == nil || result.Title != expected {
+			t.Errorf("Expected result %d to have title %q, got %+v", i, expected, result)
+		}
+	}
+
+	results, err = ExtractMany(context.Background(), inputs[:1], 4, func(d *Document) {
+		d.MinTextLength = 0
+		d.WrapWidth = 8
+	})
+	if err != nil {
+		t.Fatal("Unable to extract documents", err)
+	}
+
+	if text := results[0].Text; text != "Some\ncontent" {
+		t.Errorf("Expected the options to be applied to each document, got text %q", text)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results, err = ExtractMany(ctx, inputs, 4)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected error %v to wrap %v", err, context.Canceled)
+	}
+
+	if !strings.Contains(err.Error(), "input 0:") || !strings.Contains(err.Error(), "input 19:") {
+		t.Errorf("Expected error %q to report every input", err)
+	}
+
+	for i, result := range results {
+		if result != nil {
+			t.Errorf("Expected no result for input %d, got %+v", i, result)
+		}
+	}
+}
+
+func TestForceContentRegexp(t *testing.T) {
+	html := `<html><head><title>title!</title></head><body>
+		<div class="sidebar-layout"><div class="lede-copy"><p>The forced story, which the sidebar wrapper would otherwise take down with it.</p></div></div>
+		<div><p>Some other content, which is only here to be the best candidate.</p></div>
+	</body></html>`
+
+	doc, err := NewDocument(html)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.RetryLength = 1
+	content := doc.Content()
+	if strings.Contains(content, "The forced story") {
+		t.Errorf("Did not expect content %q to contain %q", content, "The forced story")
+	}
+
+	doc, err = NewDocument(html)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.RetryLength = 1
+	doc.ForceContentRegexp = regexp.MustCompile(`(?i)lede-copy`)
+	content = doc.Content()
+	if !strings.Contains(content, "The forced story") {
+		t.Errorf("Expected content %q to contain %q", content, "The forced story")
+	}
+}
+
+func TestForceRemoveRegexp(t *testing.T) {
+	html := `<html><head><title>title!</title></head><body><div class="post">
+		<p>The article itself, which is a fairly short piece of writing about nothing in particular, with a comma or two.</p>
+		<div class="nl-signup"><p>Sign up for our newsletter, and you will get the best of our writing, our recommendations, our offers, and our events, delivered straight to your inbox every single morning, for free.</p></div>
+		<p>The article carries on after the signup block, and finishes with another sentence, also about nothing in particular.</p>
+	</div></body></html>`
+
+	doc, err := NewDocument(html)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.RetryLength = 1
+	content := doc.Content()
+	if !strings.Contains(content, "Sign up for our newsletter") {
+		t.Errorf("Expected content %q to contain %q", content, "Sign up for our newsletter")
+	}
+
+	doc, err = NewDocument(html)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.RetryLength = 1
+	doc.ForceRemoveRegexp = regexp.MustCompile(`(?i)nl-signup`)
+	content = doc.Content()
+	if strings.Contains(content, "Sign up for our newsletter") {
+		t.Errorf("Did not expect content %q to contain %q", content, "Sign up for our newsletter")
+	}
+	if !strings.Contains(content, "The article carries on") {
+		t.Errorf("Expected content %q to contain %q", content, "The article carries on")
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/twitter_card.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/twitter_card.html", err)
+	}
+
+	base, _ := url.Parse("https://example.com/news/night-bus")
+
+	inputs := map[string]Metadata{
+		string(bytes): Metadata{
+			Title:       "Ten years of the night bus",
+			Description: "What a decade of late-night journeys taught us about the city.",
+			Image:       "https://example.com/images/night-bus.jpg",
+			Card:        "summary_large_image",
+			Creator:     "@jdoe",
+		},
+		`<html><head><meta property="og:title" content="OpenGraph title"><meta name="twitter:title" content="Twitter title"><meta property="twitter:description" content="Twitter description"></head><body></body></html>`: Metadata{
+			Title:       "OpenGraph title",
+			Description: "Twitter description",
+		},
+	}
+
+	for input, expected := range inputs {
+		doc, err := NewDocument(input)
+		if err != nil {
+			t.Fatal("Unable to create document", err)
+		}
+
+		doc.BaseURL = base
+		if metadata := doc.Metadata(); metadata != expected {
+			t.Errorf("Expected metadata %+v to be %+v", metadata, expected)
+		}
+	}
+}
+
+func TestCleanHTML(t *testing.T) {
+	input := `<html><head><title>Fish &amp; chips</title><link rel="stylesheet" href="style.css"></head><body><div><p>Some content</p></div></body>`
+	doc, err := NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.MinTextLength = 0
+	doc.RetryLength = 1
+
+	output := doc.CleanHTML()
+	if !strings.HasPrefix(output, "<!DOCTYPE html>") {
+		t.Errorf("Expected output %q to start with a doctype", output)
+	}
+
+	parsed, err := html.Parse(strings.NewReader(output))
+	if err != nil {
+		t.Fatal("Unable to parse output", err)
+	}
+
+	cleaned := goquery.NewDocumentFromNode(parsed)
+	if charset, _ := cleaned.Find("head meta").Attr("charset"); charset != "utf-8" {
+		t.Errorf("Expected output %q to declare a utf-8 charset", output)
+	}
+	if title := cleaned.Find("head title").Text(); title != "Fish & chips" {
+		t.Errorf("Expected title %q to be %q", title, "Fish & chips")
+	}
+	if text := cleaned.Find("body p").Text(); text != "Some content" {
+		t.Errorf("Expected body text %q to be %q", text, "Some content")
+	}
+	if cleaned.Find("link").Length() > 0 {
+		t.Errorf("Did not expect output %q to contain external references", output)
+	}
+}
+
+func TestRemoveBoilerplate(t *testing.T) {
+	page := `<html><head><title>title!</title></head><body><div class="post">
+		<p>%s</p>
+		<p>Our journalism is funded by readers like you, and every contribution, big or small, helps to keep it free for everyone.</p>
+	</div></body></html>`
+
+	first, err := NewDocument(fmt.Sprintf(page, "The first article is about the weather, which has been, for the most part, unremarkable this spring."))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	second, err := NewDocument(fmt.Sprintf(page, "The second article is about the trains, which have been, for the most part, late again this spring."))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	filter := NewBoilerplateFilter()
+	filter.Add(first)
+
+	first.RetryLength = 1
+	first.RemoveBoilerplate(filter)
+	if content := first.Content(); !strings.Contains(content, "Our journalism is funded by readers like you") {
+		t.Errorf("Expected content %q to contain text seen in a single document", content)
+	}
+
+	filter.Add(second)
+
+	second.RetryLength = 1
+	second.RemoveBoilerplate(filter)
+	content := second.Content()
+	if strings.Contains(content, "Our journalism is funded by readers like you") {
+		t.Errorf("Did not expect content %q to contain %q", content, "Our journalism is funded by readers like you")
+	}
+	if !strings.Contains(content, "The second article is about the trains") {
+		t.Errorf("Expected content %q to contain %q", content, "The second article is about the trains")
+	}
+
+	// the zero value is ready to use
+	zero := &BoilerplateFilter{MinOccurrences: 1}
+	zero.Add(first)
+	if !zero.isBoilerplate("The first article is about the weather, which has been, for the most part, unremarkable this spring.") {
+		t.Errorf("Expected a zero value filter to learn blocks")
+	}
+}
+
+func TestEachTextBlock(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/details_faq.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/details_faq.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	tags := make([]string, 0)
+	doc.EachTextBlock(func(tag string, text string) {
+		tags = append(tags, tag)
+	})
+
+	expected := []string{"p", "p", "div", "div", "p", "div", "p"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Expected blocks %v to be %v", tags, expected)
+	}
+}
+
+func TestScoringBonuses(t *testing.T) {
+	long := strings.Repeat("The quarry closed in the nineteen-seventies and the pit slowly filled with rainwater. ", 11)
+	short := "Quick notes: rain, wind, sun, fog, snow, and more."
+	html := fmt.Sprintf(`<html><head><title>title!</title></head><body>
+		<div><div class="a"><p>%s</p></div></div>
+		<div><div class="b"><p>%s</p></div></div>
+	</body></html>`, long, short)
+
+	for lengthBonusCap, expected := range map[int]string{3: "Quick notes", 10: "The quarry closed"} {
+		doc, err := NewDocument(html)
+		if err != nil {
+			t.Fatal("Unable to create document", err)
+		}
+
+		doc.RetryLength = 1
+		doc.LengthBonusCap = lengthBonusCap
+
+		if text := doc.Text(); !strings.HasPrefix(text, expected) {
+			t.Errorf("Expected text %q with length bonus cap %d to start with %q", text, lengthBonusCap, expected)
+		}
+	}
+}
+
+func TestWebStory(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/web_story.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/web_story.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	expected := "Five hidden beaches\n\nQuiet coves you can still have to yourself.\n\n" +
+		"Smugglers' Cove\n\nReached by a steep path through the woods, with no car park for miles.\n\n" +
+		"Seal Bay\n\nGo at low tide, and keep your distance from the seals on the rocks."
+	if text := doc.Text(); text != expected {
+		t.Errorf("Expected text %q to be %q", text, expected)
+	}
+
+	if nodes := doc.ArticleNodes(); len(nodes) != 3 {
+		t.Errorf("Expected 3 article nodes, got %d", len(nodes))
+	}
+
+	// scripts are never text, even if they aren't removed beforehand
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.NonContentTags = nil
+	if text := doc.Text(); text != expected {
+		t.Errorf("Expected text %q without non-content tags removed to be %q", text, expected)
+	}
+}
+
+func TestPublishedDate(t *testing.T) {
+	pages := map[string]string{
+		"/dated":   `<html><head><meta property="article:published_time" content="2021-03-04T05:06:07Z"></head><body><p>Some content</p></body></html>`,
+		"/undated": `<html><head><title>title!</title></head><body><p>Some content</p></body></html>`,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		fmt.Fprint(w, pages[r.URL.Path])
+	}))
+	defer server.Close()
+
+	inputs := map[string]struct {
+		date   time.Time
+		source string
+	}{
+		"/dated":   {time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC), "meta"},
+		"/undated": {time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC), "header"},
+	}
+
+	for path, expected := range inputs {
+		doc, err := NewDocumentFromURL(context.Background(), server.URL+path)
+		if err != nil {
+			t.Fatal("Unable to create document", err)
+		}
+
+		if date, source := doc.PublishedDate(); !date.Equal(expected.date) || source != expected.source {
+			t.Errorf("Expected published date %v from %q to be %v from %q", date, source, expected.date, expected.source)
+		}
+	}
+
+	doc, err := NewDocument(pages["/undated"])
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if date, source := doc.PublishedDate(); !date.IsZero() || source != "" {
+		t.Errorf("Expected no published date, got %v from %q", date, source)
+	}
+
+	// a server that never answers doesn't hang the caller
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer hung.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := NewDocumentFromURL(ctx, hung.URL); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the request to time out, got %v", err)
+	}
+
+	// options are applied before the response is read
+	phases := make([]string, 0)
+	doc, err = NewDocumentFromURL(context.Background(), server.URL+"/dated", func(d *Document) {
+		d.MaxParseBytes = 64
+		d.OnPhase = func(phase string, dur time.Duration) {
+			phases = append(phases, phase)
+		}
+	})
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if len(doc.input) > 64 {
+		t.Errorf("Expected no more than 64 bytes of the response to be read, got %d", len(doc.input))
+	}
+
+	if len(phases) == 0 || phases[0] != "parse" {
+		t.Errorf("Expected the parse phase to be reported, got %q", phases)
+	}
+}
+
+func TestPreserveScientificMarkup(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/scientific_markup.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/scientific_markup.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.RetryLength = 1
+	content := doc.Content()
+	if !strings.Contains(content, "H2CO3") {
+		t.Errorf("Expected content %q to contain %q", content, "H2CO3")
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.RetryLength = 1
+	doc.PreserveScientificMarkup = true
+	content = doc.Content()
+
+	for _, required := range []string{
+		"H<sub>2</sub>O",
+		"H<sub>2</sub>CO<sub>3</sub>",
+		"10<sup>0.1</sup>",
+		"<sup>1</sup>",
+		"<sup>2</sup>",
+	} {
+		if !strings.Contains(content, required) {
+			t.Errorf("Expected content %q to contain %q", content, required)
+		}
+	}
+}
+
+func TestDensityFallback(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/density_fallback.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/density_fallback.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+	if !strings.Contains(content, "Copyright") {
+		t.Errorf("Expected content %q to fall back to the whole body", content)
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.EnableDensityFallback = true
+	content = doc.Content()
+
+	for _, required := range []string{
+		"The first thing anyone learns about keeping bees is that the bees are in charge",
+		"enough shelter, to get through the winter without help.",
+	} {
+		if !strings.Contains(content, required) {
+			t.Errorf("Expected content %q to contain %q", content, required)
+		}
+	}
+
+	for _, excluded := range []string{"Contact", "Privacy", "Copyright"} {
+		if strings.Contains(content, excluded) {
+			t.Errorf("Did not expect content %q to contain %q", content, excluded)
+		}
+	}
+}
+
+func TestPreserveFootnotes(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/footnotes.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/footnotes.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+	if strings.Contains(content, "first circulated privately") {
+		t.Errorf("Expected content %q to exclude the notes by default", content)
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.PreserveFootnotes = true
+	content = doc.Content()
+
+	for _, required := range []string{
+		"expected revenues to collapse.<sup>1</sup>",
+		"the empire that issued it.<sup>2</sup>",
+		"first circulated privately.",
+		"went on sale on 1 May 1840.",
+	} {
+		if !strings.Contains(content, required) {
+			t.Errorf("Expected content %q to contain %q", content, required)
+		}
+	}
+
+	if strings.Contains(content, "↩") {
+		t.Errorf("Expected content %q to exclude the back references", content)
+	}
+
+	if strings.Index(content, "went on sale") < strings.Index(content, "Historians still argue") {
+		t.Errorf("Expected the notes to follow the article in %q", content)
+	}
+}
+
+func TestPreserveHighlights(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/highlights.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/highlights.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+	if strings.Contains(content, "<mark") || !strings.Contains(content, "called precipitation, and") {
+		t.Errorf("Expected content %q to flatten highlights by default", content)
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.PreserveHighlights = true
+	content = doc.Content()
+
+	for _, required := range []string{
+		"called <mark>precipitation</mark>, and",
+		"water <mark>evaporates</mark> into the air",
+	} {
+		if !strings.Contains(content, required) {
+			t.Errorf("Expected content %q to contain %q", content, required)
+		}
+	}
+}
+
+func TestTopVideo(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/video.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/video.html", err)
+	}
+
+	base, _ := url.Parse("https://example.com/watch/airship")
+
+	paragraph := "<p>The film was found in a loft last year and has been cleaned and digitised frame by frame by volunteers at the county archive, who say it is the only moving footage of the flight known to exist.</p>"
+
+	inputs := map[string]string{
+		string(bytes): "https://example.com/videos/airship.mp4",
+		`<html><head><meta name="twitter:player" content="https://player.example.com/embed/42"></head><body><p>Some content</p></body></html>`: "https://player.example.com/embed/42",
+		`<html><body><div class="article">` + paragraph + `<iframe src="https://www.youtube.com/embed/abc123"></iframe></div></body></html>`:   "https://www.youtube.com/embed/abc123",
+		`<html><body><div class="article">` + paragraph + `<video><source src="clip.webm" type="video/webm"></video></div></body></html>`:      "https://example.com/watch/clip.webm",
+		`<html><body><div class="article">` + paragraph + `<iframe src="https://ads.example.net/frame"></iframe></div></body></html>`:          "",
+		`<html><body><div class="article">` + paragraph + `</div></body></html>`:                                                               "",
+	}
+
+	for input, expected := range inputs {
+		doc, err := NewDocument(input)
+		if err != nil {
+			t.Fatal("Unable to create document", err)
+		}
+
+		doc.BaseURL = base
+		if video := doc.TopVideo(); video != expected {
+			t.Errorf("Expected top video %q to be %q", video, expected)
+		}
+	}
+}
+
+func TestSiblingParagraphMaxLinkDensity(t *testing.T) {
+	citations := `<p>This account draws on <a href="/refs/1">Hartley's survey of the canal companies</a>, <a href="/refs/2">the Board of Trade returns for 1840</a> and <a href="/refs/3">the letters of the company secretary</a>, held at the county record office.</p>`
+	input := `<html><body><div class="wrapper"><div class="entry">` +
+		`<p>The canal was dug by hand over six years, by gangs of labourers who moved from one section to the next as the work progressed, living in camps along the line that the towns nearby regarded with suspicion.</p>` +
+		`<p>When it opened, it halved the cost of carrying coal to the city, and for thirty years it paid its shareholders a dividend that was the envy of every other company in the county, until the railway arrived.</p>` +
+		`</div>` + citations + `</div></body></html>`
+
+	doc, err := NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+	if strings.Contains(content, "Board of Trade") {
+		t.Errorf("Expected content %q to exclude the citations by default", content)
+	}
+
+	doc, err = NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.SiblingParagraphMaxLinkDensity = .9
+	content = doc.Content()
+	if !strings.Contains(content, "the Board of Trade returns for 1840") {
+		t.Errorf("Expected content %q to include the citations", content)
+	}
+}
+
+func TestPreformattedWhitespace(t *testing.T) {
+	code := "func main() {\n\tfor i := 3; i != 0; i-- {\n\t\tfmt.Println(i)\n\n\t\tif i == 2 {\n\t\t\tbreak\n\t\t}\n\t}\n}"
+	input := `<html><body><div class="post-content">` +
+		`<p>Loops in Go come in a single form, the for statement, which covers everything from counting loops to ranging over a channel, and the break statement works in all of them just as you would expect it to.</p>` +
+		"\n\n\n<pre>" + code + "</pre>\n\n\n" +
+		`<p>Running the program prints three and two and then stops, because the break statement leaves the loop as soon as the counter reaches two, before the loop condition is checked again.</p>` +
+		`</div></body></html>`
+
+	doc, err := NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.WhitelistTags = append(doc.WhitelistTags, "pre")
+	content := doc.Content()
+
+	if !strings.Contains(content, "<pre>"+code+"</pre>") {
+		t.Errorf("Expected content %q to keep the whitespace of %q", content, code)
+	}
+
+	if strings.Contains(content, "</p>\n\n") {
+		t.Errorf("Expected content %q to collapse line breaks outside <pre>", content)
+	}
+}
+
+func TestPageType(t *testing.T) {
+	inputs := map[string]string{
+		"test_fixtures/page_type_article.html": "article",
+		"test_fixtures/page_type_listing.html": "listing",
+		"test_fixtures/recipe.html":            "article",
+	}
+
+	for fixture, expected := range inputs {
+		bytes, err := ioutil.ReadFile(fixture)
+		if err != nil {
+			t.Fatal("Unable to read file", fixture, err)
+		}
+
+		doc, err := NewDocument(string(bytes))
+		if err != nil {
+			t.Fatal("Unable to create document", err)
+		}
+
+		if pageType := doc.PageType(); pageType != expected {
+			t.Errorf("Expected page type of %s to be %q, got %q", fixture, expected, pageType)
+		}
+	}
+
+	doc, err := NewDocument(`<html><body><div class="contact"><p>Write to us at the address below.</p></div></body></html>`)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if pageType := doc.PageType(); pageType != "other" {
+		t.Errorf("Expected page type of a contact page to be %q, got %q", "other", pageType)
+	}
+
+	links := `<html><body><div class="archive"><p>Every story we published in May, newest first.</p><ul>` +
+		strings.Repeat(`<li><a href="/2024/05/story">Council approves plans for a new footbridge by the station</a></li>`, 10) +
+		`</ul></div><aside>` + strings.Repeat("The archive is updated every night with the previous day's stories. ", 20) + `</aside></body></html>`
+
+	doc, err = NewDocument(links)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if pageType := doc.PageType(); pageType == "listing" {
+		t.Errorf("Did not expect an archive with a long aside to have page type %q", pageType)
+	}
+
+	doc, err = NewDocument(links)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.NonContentTags = append(doc.NonContentTags, "aside")
+	if pageType := doc.PageType(); pageType != "listing" {
+		t.Errorf("Expected page type of an archive with its aside left out to be %q, got %q", "listing", pageType)
+	}
+}
+
+func TestMaxSiblingAppends(t *testing.T) {
+	article := strings.Repeat(`<p>The harbour was rebuilt after the storm, stone by stone, over three long summers that the town still talks about whenever the wind gets up.</p>`, 3)
+
+	// each sibling scores a little higher than the one before it
+	siblings := ""
+	for i := 1; i <= 6; i++ {
+		siblings += fmt.Sprintf(`<div><p>Sibling %d%s and its story of the harbour, the boats and the people who worked them through the long winters.</p></div>`, i, strings.Repeat(", again", i+3))
+	}
+
+	// a paragraph included on its own merit rather than by its score
+	own := `<p>The sea wall held.</p>`
+
+	input := `<html><body><div class="wrapper"><div class="content">` + article + `</div>` + siblings + own + `</div></body></html>`
+
+	doc, err := NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+	for i := 1; i <= 6; i++ {
+		if !strings.Contains(content, fmt.Sprintf("Sibling %d,", i)) {
+			t.Errorf("Expected content %q to contain sibling %d", content, i)
+		}
+	}
+
+	doc, err = NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.MaxSiblingAppends = 2
+	content = doc.Content()
+
+	if !strings.Contains(content, "The harbour was rebuilt") {
+		t.Errorf("Expected content %q to contain the best candidate", content)
+	}
+
+	for i := 1; i <= 6; i++ {
+		if contains := strings.Contains(content, fmt.Sprintf("Sibling %d,", i)); contains != (i >= 5) {
+			t.Errorf("Expected content %q to contain sibling %d: %t", content, i, i >= 5)
+		}
+	}
+
+	if strings.Index(content, "Sibling 5,") > strings.Index(content, "Sibling 6,") {
+		t.Errorf("Expected content %q to keep the siblings in document order", content)
+	}
+
+	if !strings.Contains(content, "The sea wall held.") {
+		t.Errorf("Expected content %q to keep the paragraph without a score", content)
+	}
+}
+
+func TestUseAccessibleText(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/accessible_text.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/accessible_text.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	text := doc.Text()
+	if !strings.Contains(text, "downloaded here and a large print version") {
+		t.Errorf("Expected text %q to have no text for icon-only links", text)
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.UseAccessibleText = true
+	text = doc.Text()
+
+	for _, required := range []string{
+		"downloaded here Annual report 2023 (PDF) and",
+		"available Large print annual report on request",
+		"summary Play summary of the year",
+	} {
+		if !strings.Contains(text, required) {
+			t.Errorf("Expected text %q to contain %q", text, required)
+		}
+	}
+}
+
+func TestStripArchiveChrome(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/wayback.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/wayback.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.StripArchiveChrome = true
+	content := doc.Content()
+
+	if !strings.Contains(content, "struck noon again on Saturday") {
+		t.Errorf("Expected content %q to contain the article", content)
+	}
+
+	for _, excluded := range []string{"Wayback Machine", "captures", "Please donate"} {
+		if strings.Contains(content, excluded) {
+			t.Errorf("Did not expect content %q to contain %q", content, excluded)
+		}
+	}
+
+	expected := map[string]string{
+		"a":   "http://example.com/news/volunteers.html",
+		"img": "http://example.com/images/clock.jpg",
+	}
+
+	for _, n := range doc.ArticleNodes() {
+		goquery.NewDocumentFromNode(n).Find("a,img").Each(func(i int, s *goquery.Selection) {
+			url, _ := s.Attr("href")
+			if s.Is("img") {
+				url, _ = s.Attr("src")
+			}
+
+			if tag := goquery.NodeName(s); url != expected[tag] {
+				t.Errorf("Expected %s URL %q to be %q", tag, url, expected[tag])
+			}
+		})
+	}
+}
+
+func TestBaseHref(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/base_href.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/base_href.html", err)
+	}
+
+	base, _ := url.Parse("https://example.org/news/spring")
+
+	for _, baseURL := range []*url.URL{nil, base} {
+		doc, err := NewDocument(string(bytes))
+		if err != nil {
+			t.Fatal("Unable to create document", err)
+		}
+
+		doc.BaseURL = baseURL
+
+		expected := "https://static.example.org/archive/2019/images/blossom.jpg"
+		if image := doc.Metadata().Image; image != expected {
+			t.Errorf("Expected image %q to be %q", image, expected)
+		}
+
+		expected = "https://static.example.org/archive/2019/authors/sam.png"
+		if image := doc.AuthorImage(); image != expected {
+			t.Errorf("Expected author image %q to be %q", image, expected)
+		}
+	}
+
+	// a relative <base> is resolved against BaseURL
+	doc, err := NewDocument(strings.Replace(string(bytes), "https://static.example.org/archive/2019/", "/static/", 1))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.BaseURL = base
+
+	expected := "https://example.org/static/images/blossom.jpg"
+	if image := doc.Metadata().Image; image != expected {
+		t.Errorf("Expected image %q to be %q", image, expected)
+	}
+}
+
+func TestSemanticOutput(t *testing.T) {
+	input := `<html><head><title>A year on the allotment</title></head><body><div class="post">` +
+		`<h1>A year on the allotment</h1>` +
+		`<p>We took on the plot in a wet January, when it was little more than a tangle of brambles and bindweed, and spent the first two months doing nothing but digging, burning and digging again.</p>` +
+		`<p>By the summer there were beans, courgettes and more lettuce than we could possibly eat, and we had learned that the old hands on the neighbouring plots were happy to give advice, whether we asked or not.</p>` +
+		`</div></body></html>`
+
+	doc, err := NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if content := doc.Content(); strings.Contains(content, "<article>") {
+		t.Errorf("Expected content %q to not be wrapped in an <article> by default", content)
+	}
+
+	doc, err = NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.SemanticOutput = true
+	content := doc.Content()
+
+	prefix := "<html><head></head><body><article><header><h1>A year on the allotment</h1></header><div><p>"
+	if !strings.HasPrefix(content, prefix) {
+		t.Errorf("Expected content %q to start with %q", content, prefix)
+	}
+
+	if !strings.HasSuffix(content, "</p></div></article></body></html>") {
+		t.Errorf("Expected content %q to end with the closing </article>", content)
+	}
+
+	if count := strings.Count(content, "A year on the allotment"); count != 1 {
+		t.Errorf("Expected content %q to contain the title once, found %d", content, count)
+	}
+}
+
+func TestTidyNestedParagraphs(t *testing.T) {
+	// without a doctype, tables don't close the paragraph they're in, so
+	// flattening the table leaves the cell's paragraph inside it
+	input := `<html><body><div class="post-body">` +
+		`<p><table><tr><td><p>The survey counted more than four hundred species of moth in the wood over a single summer, including three that had not been recorded in the county for at least fifty years.</p></td></tr></table></p>` +
+		`<p>Volunteers ran light traps on every clear night from May to September, and the records have been passed on to the national recording scheme.<b><i></i></b></p>` +
+		`</div></body></html>`
+
+	doc, err := NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.WhitelistTags = append(doc.WhitelistTags, "b")
+	content := doc.Content()
+
+	if !strings.Contains(content, "<p>The survey counted") || strings.Contains(content, "<p><p>") {
+		t.Errorf("Expected content %q to have no nested paragraphs", content)
+	}
+
+	if !strings.Contains(content, "national recording scheme.<b></b></p>") {
+		t.Errorf("Expected content %q to keep empty elements by default", content)
+	}
+
+	doc, err = NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.WhitelistTags = append(doc.WhitelistTags, "b")
+	doc.RemoveEmptyElements = true
+	content = doc.Content()
+
+	if !strings.Contains(content, "national recording scheme.</p>") {
+		t.Errorf("Expected content %q to have no empty elements", content)
+	}
+
+	// an empty cell still holds its column
+	doc, err = NewDocument(`<html><body><div class="post-body">` +
+		`<p>The survey counted more than four hundred species of moth in the wood over a single summer, including three that had not been recorded in the county for at least fifty years.</p>` +
+		`<table><tr><th>Species</th><th>Count</th><th>Notes</th></tr><tr><td>Oak eggar</td><td></td><td>Seen once</td></tr></table>` +
+		`</div></body></html>`)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.WhitelistTags = append(doc.WhitelistTags, "table", "tbody", "tr", "th", "td")
+	doc.RemoveEmptyElements = true
+	content = doc.Content()
+
+	if !strings.Contains(content, "<td>Oak eggar</td><td></td><td>Seen once</td>") {
+		t.Errorf("Expected content %q to keep the empty table cell", content)
+	}
+}
+
+func TestIncludeTitleInBody(t *testing.T) {
+	article := `<p>The ferry will stop running between the two islands at the end of the month, after the operator said that falling passenger numbers had made the route impossible to run without a subsidy.</p>` +
+		`<p>Islanders say the decision will leave the smaller island cut off for most of the winter, and have started a petition calling on the council to step in and keep the service going.</p>`
+
+	inputs := map[string]string{
+		// no heading in the article
+		`<html><head><title>Island ferry to stop running</title></head><body><div class="story">` + article + `</div></body></html>`: "<html><head></head><body><h1>Island ferry to stop running</h1><div><p>The ferry",
+		// a heading kept by the whitelist
+		`<html><head><title>Island ferry to stop running</title></head><body><div class="story"><h1>Island ferry to stop running</h1>` + article + `</div></body></html>`: "<html><head></head><body><div><h1>Island ferry to stop running</h1><p>The ferry",
+	}
+
+	for input, expected := range inputs {
+		doc, err := NewDocument(input)
+		if err != nil {
+			t.Fatal("Unable to create document", err)
+		}
+
+		doc.WhitelistTags = append(doc.WhitelistTags, "h1")
+		doc.IncludeTitleInBody = true
+		content := doc.Content()
+
+		if !strings.HasPrefix(content, expected) {
+			t.Errorf("Expected content %q to start with %q", content, expected)
+		}
+
+		if count := strings.Count(content, "Island ferry to stop running"); count != 1 {
+			t.Errorf("Expected content %q to contain the title once, found %d", content, count)
+		}
+	}
+}
+
+func TestMinImageSize(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/image_sizes.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/image_sizes.html", err)
+	}
+
+	inputs := map[int]int{
+		0:  5,
+		32: 2,
+	}
+
+	for size, expected := range inputs {
+		doc, err := NewDocument(string(bytes))
+		if err != nil {
+			t.Fatal("Unable to create document", err)
+		}
+
+		doc.WhitelistTags = append(doc.WhitelistTags, "img")
+		doc.MinImageWidth = size
+		doc.MinImageHeight = size
+
+		content := doc.Content()
+		if count := strings.Count(content, "<img"); count != expected {
+			t.Errorf("Expected content %q to have %d images with a minimum size of %d, found %d", content, expected, size, count)
+		}
+	}
+}
+
+func TestCountSyllables(t *testing.T) {
+	inputs := map[string]int{
+		"the":         1,
+		"cat":         1,
+		"cake":        1,
+		"table":       2,
+		"syllable":    3,
+		"reading":     2,
+		"readability": 5,
+		"rhythm":      1,
+		"Education":   4,
+	}
+
+	for word, expected := range inputs {
+		if count := countSyllables(word); count != expected {
+			t.Errorf("Expected %q to have %d syllables, got %d", word, expected, count)
+		}
+	}
+}
+
+func TestReadabilityMetrics(t *testing.T) {
+	doc, err := NewDocument(`<html><body><div><p>The cat sat on the mat. The dog ran.</p></div></body></html>`)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.MinTextLength = 0
+	doc.RetryLength = 1
+
+	metrics := doc.ReadabilityMetrics()
+	if metrics.Sentences != 2 || metrics.Words != 9 || metrics.Syllables != 9 {
+		t.Errorf("Expected 2 sentences, 9 words and 9 syllables, got %+v", metrics)
+	}
+
+	// 206.835 - 1.015 * 9/2 - 84.6 * 9/9
+	if math.Abs(metrics.FleschReadingEase-117.6675) > 0.0001 {
+		t.Errorf("Expected reading ease of 117.6675, got %f", metrics.FleschReadingEase)
+	}
+
+	// 0.39 * 9/2 + 11.8 * 9/9 - 15.59
+	if math.Abs(metrics.FleschKincaidGrade-(-2.035)) > 0.0001 {
+		t.Errorf("Expected grade level of -2.035, got %f", metrics.FleschKincaidGrade)
+	}
+}
+
+func TestPreserveTimeElements(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/time_elements.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/time_elements.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+	if strings.Contains(content, "<time") || !strings.Contains(content, "from Monday 3 June while") {
+		t.Errorf("Expected content %q to flatten <time> by default", content)
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.PreserveTimeElements = true
+	content = doc.Content()
+
+	for _, required := range []string{
+		`<time datetime="2024-06-03">Monday 3 June</time>`,
+		`<time datetime="2024-08-30T06:00:00+01:00">30 August at 6am</time>`,
+	} {
+		if !strings.Contains(content, required) {
+			t.Errorf("Expected content %q to contain %q", content, required)
+		}
+	}
+}
+
+func TestContentColumns(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/columns.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/columns.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if content := doc.Content(); strings.Contains(content, "In the winter of 1963 the river froze") && strings.Contains(content, "The thaw, when it came, was sudden") {
+		t.Errorf("Expected content %q to have only one column by default", content)
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.MergeColumns = true
+	content := doc.Content()
+
+	first := strings.Index(content, "In the winter of 1963 the river froze")
+	second := strings.Index(content, "The thaw, when it came, was sudden")
+
+	if first < 0 || second < 0 {
+		t.Errorf("Expected content %q to contain both columns", content)
+	} else if first > second {
+		t.Errorf("Expected content %q to keep the columns in document order", content)
+	}
+
+	if strings.Contains(content, "Evening Post") {
+		t.Errorf("Did not expect content %q to contain the masthead", content)
+	}
+
+	// grid columns of different widths are the article and its sidebar
+	paragraph := `<p>The ferry to the island runs twice a day in summer, weather permitting, and once a day in winter, when the crossing can take twice as long.</p>`
+	input := `<html><body><div class="row"><div class="col-md-8"><div>` + strings.Repeat(paragraph, 3) + `</div></div><div class="col-md-4"><div><div>` + strings.Repeat(paragraph, 2) + `</div></div></div></div></body></html>`
+
+	doc, err = NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.MergeColumns = true
+	if content := doc.Content(); strings.Count(content, "The ferry to the island") != 3 {
+		t.Errorf("Expected content %q to leave out the sidebar column", content)
+	}
+}
+
+func TestPreserveAnchors(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/anchors.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/anchors.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.WhitelistTags = append(doc.WhitelistTags, "a", "h2", "ol", "li")
+	content := doc.Content()
+	if strings.Contains(content, "id=") || strings.Contains(content, "href=") {
+		t.Errorf("Expected content %q to have no anchors by default", content)
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.WhitelistTags = append(doc.WhitelistTags, "a", "h2", "ol", "li")
+	doc.PreserveAnchors = true
+	content = doc.Content()
+
+	for _, required := range []string{
+		`<a href="#housing">Housing</a>`,
+		`<a href="#feeding">Feeding</a>`,
+		`<a href="#health">Health</a>`,
+		`<h2 id="housing">Housing</h2>`,
+		`<h2 id="feeding">Feeding</h2>`,
+		`<h2 id="health">Health</h2>`,
+		`<h2>Further reading</h2>`,
+	} {
+		if !strings.Contains(content, required) {
+			t.Errorf("Expected content %q to contain %q", content, required)
+		}
+	}
+}
+
+func TestRSSItem(t *testing.T) {
+	input := `<html><head><title>Tide tables &amp; ferry times</title>` +
+		`<link rel="canonical" href="/news/tides">` +
+		`<meta property="article:published_time" content="2024-03-01T09:30:00Z">` +
+		`</head><body><div class="article">` +
+		`<p>The spring tides this weekend will be the highest of the year, and the harbour master has warned that the causeway will be under water for longer than usual on Saturday and Sunday.</p>` +
+		`<p>Ferry times have been changed to match, and passengers should check before travelling, as the last crossing on both days will leave half an hour earlier than shown in the timetable ]]> here.</p>` +
+		`</div></body></html>`
+
+	doc, err := NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.BaseURL, _ = url.Parse("https://example.com/news/tides?ref=home")
+	item := doc.RSSItem()
+
+	var parsed struct {
+		XMLName     xml.Name `xml:"item"`
+		Title       string   `xml:"title"`
+		Link        string   `xml:"link"`
+		Description string   `xml:"description"`
+		PubDate     string   `xml:"pubDate"`
+		Encoded     string   `xml:"encoded"`
+	}
+
+	if err := xml.Unmarshal([]byte(item), &parsed); err != nil {
+		t.Fatalf("Expected item %q to be valid XML: %s", item, err)
+	}
+
+	if parsed.Title != "Tide tables & ferry times" {
+		t.Errorf("Expected title %q to be %q", parsed.Title, "Tide tables & ferry times")
+	}
+
+	if parsed.Link != "https://example.com/news/tides" {
+		t.Errorf("Expected link %q to be the canonical URL", parsed.Link)
+	}
+
+	if !strings.HasPrefix(parsed.Description, "The spring tides this weekend") {
+		t.Errorf("Expected description %q to be the first paragraph", parsed.Description)
+	}
+
+	if parsed.PubDate != "Fri, 01 Mar 2024 09:30:00 +0000" {
+		t.Errorf("Expected pubDate %q to be %q", parsed.PubDate, "Fri, 01 Mar 2024 09:30:00 +0000")
+	}
+
+	if !strings.HasPrefix(parsed.Encoded, "<div><p>The spring tides") || !strings.Contains(parsed.Encoded, "timetable ]]&gt; here.") {
+		t.Errorf("Expected content %q to be the article HTML", parsed.Encoded)
+	}
+}
+
+func TestStripDecorativeParagraphs(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/decorative_paragraphs.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/decorative_paragraphs.html", err)
+	}
+
+	decorative := []string{"<p>• • •</p>", "<p>——— ✿ ———</p>", "<p>🌲🌲🌲</p>"}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+	for _, separator := range decorative {
+		if !strings.Contains(content, separator) {
+			t.Errorf("Expected content %q to contain %q by default", content, separator)
+		}
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.StripDecorativeParagraphs = true
+	content = doc.Content()
+
+	for _, separator := range decorative {
+		if strings.Contains(content, separator) {
+			t.Errorf("Did not expect content %q to contain %q", content, separator)
+		}
+	}
+
+	for _, required := range []string{"The first walk", "The second climbs", "The third is barely", "<p>5 ★</p>"} {
+		if !strings.Contains(content, required) {
+			t.Errorf("Expected content %q to contain %q", content, required)
+		}
+	}
+}
+
+func TestIncludeHiddenContent(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/hidden_content.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/hidden_content.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+	if strings.Contains(content, "seventy-eight") {
+		t.Errorf("Expected content %q to exclude the hidden story by default", content)
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.IncludeHiddenContent = true
+	content = doc.Content()
+
+	for _, required := range []string{
+		"Now, at seventy-eight, he has decided",
+		"they will find a way to keep one.",
+	} {
+		if !strings.Contains(content, required) {
+			t.Errorf("Expected content %q to contain %q", content, required)
+		}
+	}
+
+	for _, excluded := range []string{"Features", "Please accept cookies"} {
+		if strings.Contains(content, excluded) {
+			t.Errorf("Did not expect content %q to contain %q", content, excluded)
+		}
+	}
+}
+
+func TestTagScores(t *testing.T) {
+	paragraph := `<p>The lock gates were replaced over the winter, the first time in more than a century, and the canal reopened to boats at Easter, with a queue of narrowboats waiting at either end.</p>`
+	input := `<html><body><div>` + paragraph + `</div><section>` + paragraph + `</section></body></html>`
+
+	best := func(doc *Document) string {
+		name, score := "", float32(math.Inf(-1))
+		for n, breakdown := range doc.ScoreBreakdowns() {
+			if breakdown.Score > score {
+				name, score = n.Data, breakdown.Score
+			}
+		}
+		return name
+	}
+
+	doc, err := NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if name := best(doc); name != "div" {
+		t.Errorf("Expected the best candidate to be a div by default, got %s", name)
+	}
+
+	doc, err = NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.TagScores["div"] = 0
+	doc.TagScores["section"] = 10
+
+	if name := best(doc); name != "section" {
+		t.Errorf("Expected the best candidate to be a section, got %s", name)
+	}
+
+	for n, breakdown := range doc.ScoreBreakdowns() {
+		if n.Data == "section" && breakdown.TagScore != 10 {
+			t.Errorf("Expected the section's tag score to be 10, got %f", breakdown.TagScore)
+		}
+	}
+
+	// the score of a blockquote replaces its class weight
+	doc, err = NewDocument(`<html><body><blockquote class="article-quote">` + paragraph + `</blockquote></body></html>`)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.RetryLength = 1
+
+	for n, breakdown := range doc.ScoreBreakdowns() {
+		if n.Data == "blockquote" && (breakdown.TagScore != 3 || breakdown.ClassWeight != 0) {
+			t.Errorf("Expected the blockquote's tag score to be 3 without a class weight, got %f and %f", breakdown.TagScore, breakdown.ClassWeight)
+		}
+	}
+}
+
+func TestCompactWhitespace(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/channel4-1.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/channel4-1.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.CompactWhitespace = true
+	content := doc.Content()
+
+	blankLine := regexp.MustCompile(`\n[ \t]*\n`)
+	if loc := blankLine.FindStringIndex(content); loc != nil {
+		t.Errorf("Expected no blank lines in content, found one at %d in %q", loc[0], content)
+	}
+
+	if strings.TrimSpace(content) != content {
+		t.Errorf("Expected content %q to have no leading or trailing whitespace", content)
+	}
+
+	if !strings.Contains(content, "<p>") {
+		t.Errorf("Expected content %q to keep its paragraphs", content)
+	}
+}
+
+func TestExtractLiveBlog(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/live_blog.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/live_blog.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.ExtractLiveBlog = true
+	doc.PreserveTimeElements = true
+	content := doc.Content()
+
+	order := []string{
+		`<time datetime="2023-11-14T22:00:00Z">22:00 GMT</time>`,
+		"Good evening and welcome",
+		`<time datetime="2023-11-14T23:00:00Z">23:00 GMT</time>`,
+		"Turnout appears to be higher",
+		`<time datetime="2023-11-15T00:00:00Z">00:00 GMT</time>`,
+		"Polls have now closed",
+	}
+
+	last := -1
+	for _, text := range order {
+		i := strings.Index(content, text)
+		if i < 0 {
+			t.Fatalf("Expected content %q to contain %q", content, text)
+		}
+		if i < last {
+			t.Errorf("Expected %q to come after the previous entry in %q", text, content)
+		}
+		last = i
+	}
+
+	if strings.Contains(content, "Copyright") {
+		t.Errorf("Expected content %q to exclude the footer", content)
+	}
+
+	if strings.Contains(content, "renderTurnoutChart") || strings.Contains(content, "color: red") {
+		t.Errorf("Expected content %q to exclude scripts and styles", content)
+	}
+}
+
+func TestPreserveAbbreviations(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/abbreviations.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/abbreviations.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+	if strings.Contains(content, "<abbr") || !strings.Contains(content, "The CAA has") {
+		t.Errorf("Expected content %q to flatten abbreviations by default", content)
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.PreserveAbbreviations = true
+	content = doc.Content()
+
+	for _, required := range []string{
+		`The <abbr title="Civil Aviation Authority">CAA</abbr> has`,
+		`the <abbr title="National Police Chiefs&#39; Council">NPCC</abbr> to`,
+	} {
+		if !strings.Contains(content, required) {
+			t.Errorf("Expected content %q to contain %q", content, required)
+		}
+	}
+}
+
+func TestShingles(t *testing.T) {
+	original := `<html><body><div class="article-body">
+<p>The city council voted on Tuesday night to close the old library on Main Street, ending more than a century of service to the neighbourhood. Officials said the building needed repairs the city could not afford.</p>
+<p>Residents who packed the meeting urged the council to reconsider, and several promised to raise money to keep the doors open until a new branch is built on the other side of the river.</p>
+</div></body></html>`
+
+	syndicated := `<html><body><div class="story">
+<p>The City Council voted on Tuesday night to close the old library on Main Street, ending more than a century of service to the neighborhood. Officials said the building needed repairs the city could not afford.</p>
+<p>Residents who packed the meeting urged the council to reconsider, and several promised to raise funds to keep the doors open until a new branch is built on the other side of the river.</p>
+</div></body></html>`
+
+	unrelated := `<html><body><div class="article-body">
+<p>The home side came from two goals down to win in the final minutes on Saturday, with the substitute striker scoring twice after coming off the bench early in the second half of a scrappy game.</p>
+<p>The manager praised the spirit of his players afterwards, but admitted that the defending in the first half had not been good enough and would need to improve before next week's derby.</p>
+</div></body></html>`
+
+	shingles := func(s string) []uint64 {
+		doc, err := NewDocument(s)
+		if err != nil {
+			t.Fatal("Unable to create document", err)
+		}
+		return doc.Shingles(3)
+	}
+
+	a, b, c := shingles(original), shingles(syndicated), shingles(unrelated)
+	if len(a) == 0 {
+		t.Fatal("Expected shingles for the article text")
+	}
+
+	if similarity := Jaccard(a, b); similarity < 0.7 || similarity == 1 {
+		t.Errorf("Expected lightly edited copies to be similar but not identical, got %f", similarity)
+	}
+
+	if similarity := Jaccard(a, c); similarity > 0.1 {
+		t.Errorf("Expected unrelated articles to be dissimilar, got %f", similarity)
+	}
+
+	if similarity := Jaccard(a, a); similarity != 1 {
+		t.Errorf("Expected an article to be identical to itself, got %f", similarity)
+	}
+}
+
+func TestWordBreaks(t *testing.T) {
+	doc, err := NewDocument(`<html><body><div class="article-body">
+<p>The full report is available at https://www.example.com/<wbr>reports/<wbr/>2023/<wbr>annual-review.pdf and covers every department in considerable detail, including the budget for the coming financial year and the plans for new schools and roads in the county.</p>
+<p>Councillors will debate the report at their next meeting, which is open to the public and will also be streamed online for those who are not able to attend in person.</p>
+</div></body></html>`)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+	if strings.Contains(content, "wbr") {
+		t.Errorf("Expected content %q to have no <wbr> elements", content)
+	}
+
+	url := "https://www.example.com/reports/2023/annual-review.pdf"
+	found := false
+	for _, word := range strings.Fields(doc.Text()) {
+		if word == url {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Errorf("Expected text %q to contain %q as a single word", doc.Text(), url)
+	}
+
+	p := doc.document.Find("p").First().Get(0)
+	if p.FirstChild == nil || p.FirstChild != p.LastChild {
+		t.Errorf("Expected the text around <wbr> elements to be joined into one node")
+	}
+}
+
+func TestKeepTableCaptions(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/table_caption.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/table_caption.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+	if strings.Contains(content, "<p>Rainfall in millimetres, March to May</p>") {
+		t.Errorf("Expected content %q to flatten the caption by default", content)
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.KeepTableCaptions = true
+	content = doc.Content()
+
+	caption := strings.Index(content, "<p>Rainfall in millimetres, March to May</p>")
+	if caption < 0 {
+		t.Fatalf("Expected content %q to keep the caption as a paragraph", content)
+	}
+
+	if cells := strings.Index(content, "North"); cells < caption {
+		t.Errorf("Expected the caption to come before the table's cells in %q", content)
+	}
+
+	if strings.Count(content, "Rainfall in millimetres") != 1 {
+		t.Errorf("Expected the caption to appear once in %q", content)
+	}
+}
+
+func TestReadingTime(t *testing.T) {
+	doc, err := NewDocument(`<html><body><div class="article-body"><p>` + strings.Repeat("word ", 500) + `</p></div></body></html>`)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if count := doc.WordCount(); count != 500 {
+		t.Errorf("Expected 500 words, got %d", count)
+	}
+
+	if reading := doc.ReadingTime(); reading != 150*time.Second {
+		t.Errorf("Expected a reading time of 2m30s, got %s", reading)
+	}
+}
+
+func TestSectionCandidates(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/sections.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/sections.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+
+	if doc.bestCandidate == nil || !doc.bestCandidate.selection.Is("section") {
+		t.Errorf("Expected the best candidate to be a section")
+	}
+
+	for n, breakdown := range doc.ScoreBreakdowns() {
+		if n.Data == "section" && breakdown.TagScore != 3 {
+			t.Errorf("Expected the section's tag score to be 3, got %f", breakdown.TagScore)
+		}
+	}
+
+	for _, required := range []string{
+		"<p>When the last of the old trams was taken off the streets in 1962",
+		"<p>Today the network carries more than twenty million passengers a year",
+	} {
+		if !strings.Contains(content, required) {
+			t.Errorf("Expected content %q to contain %q", content, required)
+		}
+	}
+
+	for _, excluded := range []string{"Transport", "Great article"} {
+		if strings.Contains(content, excluded) {
+			t.Errorf("Did not expect content %q to contain %q", content, excluded)
+		}
+	}
+}
+
+func TestRetryStrategy(t *testing.T) {
+	input := `<html><body><div class="article-body"><p>A short notice that the library will be closed on Monday for the public holiday.</p></div></body></html>`
+
+	doc, err := NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.Content()
+	if doc.RemoveUnlikelyCandidates || doc.WeightClasses || doc.CleanConditionally {
+		t.Errorf("Expected the default strategy to relax every option on a short page")
+	}
+
+	doc, err = NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.RetryStrategy = []RetryStep{SkipConditionalCleaning}
+	content := doc.Content()
+
+	if !doc.RemoveUnlikelyCandidates || !doc.WeightClasses {
+		t.Errorf("Expected the custom strategy to leave unlikely candidates and class weights alone")
+	}
+
+	if doc.CleanConditionally {
+		t.Errorf("Expected the custom strategy to disable conditional cleaning")
+	}
+
+	if !strings.Contains(content, "the library will be closed on Monday") {
+		t.Errorf("Expected content %q to contain the notice", content)
+	}
+
+	doc, err = NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.RetryStrategy = nil
+	doc.Content()
+
+	if !doc.RemoveUnlikelyCandidates || !doc.WeightClasses || !doc.CleanConditionally {
+		t.Errorf("Expected an empty strategy to disable retries")
+	}
+}
+
+func TestPreserveMath(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/math.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/math.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+	if strings.Contains(content, "<math") || strings.Contains(content, "Delta") {
+		t.Errorf("Expected content %q to flatten MathML and drop LaTeX scripts by default", content)
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.PreserveMath = true
+	content = doc.Content()
+
+	for _, required := range []string{
+		`<math display="block"><mi>x</mi><mo>=</mo><mfrac>`,
+		`<msup><mi>b</mi><mn>2</mn></msup>`,
+		`root, \(b^2 - 4ac\), is called`,
+		`\[\Delta = b^2 - 4ac\]`,
+	} {
+		if !strings.Contains(content, required) {
+			t.Errorf("Expected content %q to contain %q", content, required)
+		}
+	}
+
+	if strings.Contains(content, "b^2-4ac") {
+		t.Errorf("Expected content %q to drop the rendered MathJax preview", content)
+	}
+}
+
+func TestInferSemanticsFromStyle(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/styled_spans.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/styled_spans.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.WhitelistTags = append(doc.WhitelistTags, "strong", "em")
+	content := doc.Content()
+	if strings.Contains(content, "<strong>") || strings.Contains(content, "<em>") {
+		t.Errorf("Expected content %q to flatten styled spans by default", content)
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.WhitelistTags = append(doc.WhitelistTags, "strong", "em")
+	doc.InferSemanticsFromStyle = true
+	content = doc.Content()
+
+	for _, required := range []string{
+		"would be <strong>a disaster for local shops</strong> already",
+		"was <em>determined to listen</em> to businesses",
+		"would be <strong><em>fair to everyone</em></strong> who uses",
+	} {
+		if !strings.Contains(content, required) {
+			t.Errorf("Expected content %q to contain %q", content, required)
+		}
+	}
+}
+
+func TestCustomBlockTags(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/custom_elements.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/custom_elements.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+	if !strings.Contains(content, "Copyright") {
+		t.Errorf("Expected content %q to fall back to the whole body without custom block tags", content)
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.CustomBlockTags = []string{"rich-text", "cms-paragraph"}
+	content = doc.Content()
+
+	for _, required := range []string{
+		"<p>Thirty years ago there were only a handful of breeding pairs",
+		"<p>Conservationists say the recovery is one of the great success stories",
+	} {
+		if !strings.Contains(content, required) {
+			t.Errorf("Expected content %q to contain %q", content, required)
+		}
+	}
+
+	for _, excluded := range []string{"Travel", "Copyright"} {
+		if strings.Contains(content, excluded) {
+			t.Errorf("Did not expect content %q to contain %q", content, excluded)
+		}
+	}
+}
+
+func TestContentWithParagraphIDs(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/sections.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/sections.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.ContentWithParagraphIDs()
+
+	ids := regexp.MustCompile(`<p data-p="(\d+)">`).FindAllStringSubmatch(content, -1)
+	if len(ids) != 5 {
+		t.Fatalf("Expected 5 numbered paragraphs in %q, got %d", content, len(ids))
+	}
+
+	for i, id := range ids {
+		if id[1] != strconv.Itoa(i) {
+			t.Errorf("Expected paragraph %d to have id %d, got %s", i, i, id[1])
+		}
+	}
+
+	if !strings.Contains(content, `<p data-p="0">When the last of the old trams`) {
+		t.Errorf("Expected the first paragraph to be numbered 0 in %q", content)
+	}
+
+	if strings.Contains(doc.Content(), "data-p") {
+		t.Errorf("Expected Content to be left without paragraph ids")
+	}
+}
+
+func TestTextHeavyNavigation(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/text_heavy_nav.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/text_heavy_nav.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+
+	if !strings.Contains(content, "Sailings between the mainland and the northern islands") {
+		t.Errorf("Expected content %q to contain the article", content)
+	}
+
+	for _, excluded := range []string{"Timetables:", "Our company:"} {
+		if strings.Contains(content, excluded) {
+			t.Errorf("Did not expect content %q to contain %q", content, excluded)
+		}
+	}
+}
+
+func TestOutputFormat(t *testing.T) {
+	input := "<html><body><div class=\"article-body\"><p>The harbour wall was finally repaired this week,<br>more than a year after it was breached in the January storms, and fishing boats can once again shelter in the inner basin when the wind swings round to the north-east.\x0b</p><p>The council says the work cost less than expected and finished a month early, and that the money saved will go towards new lighting along the quay and a slipway for the lifeboat.</p></div></body></html>"
+
+	doc, err := NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.WhitelistTags = append(doc.WhitelistTags, "br")
+	content := doc.Content()
+
+	if strings.Contains(content, "xmlns") {
+		t.Errorf("Expected content %q to be plain HTML by default", content)
+	}
+
+	doc, err = NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.WhitelistTags = append(doc.WhitelistTags, "br")
+	doc.OutputFormat = "xhtml"
+	content = doc.Content()
+
+	if !strings.Contains(content, "this week,<br/>more than a year") {
+		t.Errorf("Expected content %q to self-close <br/>", content)
+	}
+
+	if !strings.Contains(content, `<html xmlns="http://www.w3.org/1999/xhtml">`) {
+		t.Errorf("Expected content %q to be in the XHTML namespace", content)
+	}
+
+	if err := xml.Unmarshal([]byte(content), new(interface{})); err != nil {
+		t.Errorf("Expected content %q to be well-formed XML: %s", content, err)
+	}
+}
+
+func TestArticleOffsets(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/article_offsets.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/article_offsets.html", err)
+	}
+
+	input := string(bytes)
+	doc, err := NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	start, end, ok := doc.ArticleOffsets()
+	if !ok {
+		t.Fatal("Expected to find the article in the input")
+	}
+
+	article := input[start:end]
+	if !strings.HasPrefix(article, `<div class="article-body">`) || !strings.HasSuffix(article, "</div>") {
+		t.Errorf("Expected offsets to cover the article's <div>, got %q", article)
+	}
+
+	if !strings.Contains(article, "it's where everybody meets") || !strings.Contains(article, "café in the back room") {
+		t.Errorf("Expected %q to contain the whole article", article)
+	}
+
+	if strings.Contains(article, "Most read") {
+		t.Errorf("Did not expect %q to contain the sidebar", article)
+	}
+
+	if doc.Content(); doc.sourceTags != nil {
+		t.Errorf("Expected the page's elements to be indexed only to find the offsets")
+	}
+
+	if _, _, ok := sourceOffsets(`<div><p>one<p>two</div>`, sourceTag{name: "p", index: 0}); !ok {
+		t.Errorf("Expected to find an unclosed element")
+	} else if start, end, _ := sourceOffsets(`<div><p>one<p>two</div>`, sourceTag{name: "p", index: 1}); start != 11 || end != 17 {
+		t.Errorf("Expected an unclosed element to end with its parent, got %d to %d", start, end)
+	}
+}
+
+func TestSections(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/headed_sections.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/headed_sections.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	sections := doc.Sections()
+
+	expected := []struct {
+		heading string
+		level   int
+		text    string
+	}{
+		{"", 0, "Sourdough has a reputation for being difficult"},
+		{"Making a starter", 2, "Mix equal weights of flour and water"},
+		{"Mixing the dough", 2, "Once the starter doubles in size"},
+		{"Folding", 3, "Instead of kneading"},
+		{"Baking", 2, "Bake in a very hot oven"},
+	}
+
+	if len(sections) != len(expected) {
+		t.Fatalf("Expected %d sections, got %d: %+v", len(expected), len(sections), sections)
+	}
+
+	for i, e := range expected {
+		section := sections[i]
+		if section.Heading != e.heading || section.Level != e.level {
+			t.Errorf("Expected section %d to be %q at level %d, got %q at level %d", i, e.heading, e.level, section.Heading, section.Level)
+		}
+
+		if !strings.HasPrefix(section.Text, e.text) || strings.Contains(section.Text, "\n") {
+			t.Errorf("Expected section %d to hold a single paragraph starting %q, got %q", i, e.text, section.Text)
+		}
+
+		if !strings.HasPrefix(section.HTML, "<p>"+e.text) {
+			t.Errorf("Expected section %d to have HTML starting %q, got %q", i, "<p>"+e.text, section.HTML)
+		}
+	}
+
+	if strings.Contains(doc.Content(), "<h2>") {
+		t.Errorf("Expected headings to be left out of Content")
+	}
+}
+
+func TestSocialEmbeds(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/embedded_tweet.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/embedded_tweet.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+
+	for _, required := range []string{
+		`<p>https://twitter.com/CountyRoads/status/1234567890?ref_src=twsrc%5Etfw</p>`,
+		`<p>https://www.instagram.com/p/XYZ789/</p>`,
+	} {
+		if !strings.Contains(content, required) {
+			t.Errorf("Expected content %q to contain %q", content, required)
+		}
+	}
+
+	for _, excluded := range []string{"Gritters have been out all night", "View this post on Instagram", "widgets.js"} {
+		if strings.Contains(content, excluded) {
+			t.Errorf("Did not expect content %q to contain %q", content, excluded)
+		}
+	}
+}
+
+func TestMaxCandidateLinkDensity(t *testing.T) {
+	link := `<p><a href="/news/cycle-lanes">Council approves new cycle lanes along the seafront</a> Traders say the loss of parking, on top of rising rents, will hurt them.</p>`
+	input := `<html><body><section><div>` + strings.Repeat(link, 8) + `</div></section><section><div><p>The council has approved plans for a new cycle lane along the length of the seafront, after a long debate in which traders argued that losing parking spaces would hurt their businesses.</p></div></section></body></html>`
+
+	doc, err := NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content := doc.Content()
+	if !strings.Contains(content, "Traders say") || strings.Contains(content, "after a long debate") {
+		t.Errorf("Expected the list of links to win by default, got %q", content)
+	}
+
+	doc, err = NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.MaxCandidateLinkDensity = .4
+	content = doc.Content()
+
+	if !strings.Contains(content, "after a long debate") {
+		t.Errorf("Expected content %q to contain the article", content)
+	}
+
+	if strings.Contains(content, "Traders say") {
+		t.Errorf("Expected content %q to exclude the list of links", content)
+	}
+}
+
+func TestPullQuotes(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/pull_quote.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/pull_quote.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	text := doc.Text()
+	if count := strings.Count(text, "The sea doesn't care whether anyone is watching."); count != 1 {
+		t.Errorf("Expected the quoted sentence once in %q, got %d", text, count)
+	}
+
+	if strings.Contains(text, "Twenty-six years, every evening at dusk") {
+		t.Errorf("Expected text %q to exclude the pull quotes", text)
+	}
+
+	expected := []string{
+		`"The sea doesn't care whether anyone is watching. But I did."`,
+		"Twenty-six years, every evening at dusk",
+	}
+
+	if quotes := doc.PullQuotes(); !reflect.DeepEqual(quotes, expected) {
+		t.Errorf("Expected pull quotes %q, got %q", expected, quotes)
+	}
+}
+
+func TestMaxParseBytes(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/channel4-1.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/channel4-1.html", err)
+	}
+
+	// a huge page with the article at the top
+	page := string(bytes)
+	end := strings.LastIndex(page, "</body>")
+	product := `<div class="product"><img src="/p.jpg" alt="Widget"><a href="/p">Widget — £9.99</a></div>`
+	page = page[:end] + strings.Repeat(product, 50000) + page[end:]
+
+	doc, err := NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if doc.input != page {
+		t.Errorf("Expected the whole page to be read by default")
+	}
+
+	limit := end + 10*len(product) + 5
+	doc, err = NewDocumentFromReader(strings.NewReader(page), func(d *Document) {
+		d.MaxParseBytes = limit
+	})
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if len(doc.input) > limit || !strings.HasSuffix(doc.input, "</div>") {
+		t.Errorf("Expected the page to be cut at the last complete tag within %d bytes, got %q", limit, doc.input[len(doc.input)-40:])
+	}
+
+	if content := doc.Content(); !strings.Contains(content, "A US judge has ruled that prison officials may continue force-feeding") {
+		t.Errorf("Expected content %q to contain the article", content)
+	}
+
+	// cut within a multi-byte character and a tag
+	if s := truncateHtml("<p>£9.99</p><a hr"); s != "<p>£9.99</p>" {
+		t.Errorf("Expected truncation before the unfinished tag, got %q", s)
+	}
+
+	if s := truncateHtml("<p>Widget \xc2"); s != "<p>Widget " {
+		t.Errorf("Expected truncation before the unfinished character, got %q", s)
+	}
+}
+
+func TestPictureElements(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/picture.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/picture.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	nodes := doc.ArticleNodes()
+	if len(nodes) == 0 {
+		t.Fatal("Expected article nodes")
+	}
+
+	article := goquery.NewDocumentFromNode(nodes[0])
+	if article.Find("picture,source").Length() > 0 {
+		t.Errorf("Expected <picture> and <source> elements to be collapsed")
+	}
+
+	srcs := make([]string, 0)
+	article.Find("img").Each(func(i int, img *goquery.Selection) {
+		src, _ := img.Attr("src")
+		srcs = append(srcs, src)
+	})
+
+	expected := []string{"/img/glasshouse-1600.webp", "/img/palms.jpg"}
+	if !reflect.DeepEqual(srcs, expected) {
+		t.Errorf("Expected images %q, got %q", expected, srcs)
+	}
+
+	if src := largestSrcsetURL("a.jpg, b.jpg 2x, c.jpg 1.5x"); src != "b.jpg" {
+		t.Errorf("Expected the highest density image, got %q", src)
+	}
+}
+
+func TestContentDocument(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/sections.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/sections.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	content, err := doc.ContentDocument()
+	if err != nil {
+		t.Fatal("Unable to get content document", err)
+	}
+
+	paragraphs := content.Find("body p")
+	if paragraphs.Length() != 5 {
+		t.Errorf("Expected 5 paragraphs, got %d", paragraphs.Length())
+	}
+
+	if first := paragraphs.First().Text(); !strings.HasPrefix(first, "When the last of the old trams") {
+		t.Errorf("Expected the first paragraph to start the article, got %q", first)
+	}
+
+	if content.Find("nav").Length() > 0 {
+		t.Errorf("Expected the document to hold only the article")
+	}
+
+	// changes to the document leave the extracted content alone
+	removeNodes(paragraphs)
+	if !strings.Contains(doc.Content(), "When the last of the old trams") {
+		t.Errorf("Expected the content document to be independent of the document")
+	}
+}
+
+func TestXHTMLInput(t *testing.T) {
+	for _, fixture := range []string{"test_fixtures/xhtml.xhtml", "test_fixtures/xhtml_default_namespace.xhtml"} {
+		bytes, err := ioutil.ReadFile(fixture)
+		if err != nil {
+			t.Fatal("Unable to read file", fixture, err)
+		}
+
+		doc, err := NewDocument(string(bytes))
+		if err != nil {
+			t.Fatal("Unable to create document", err)
+		}
+
+		if title := doc.Title(); title != "Orchard revival brings back forgotten apples" {
+			t.Errorf("Expected the title of %s from its <title>, got %q", fixture, title)
+		}
+
+		content := doc.Content()
+
+		for _, required := range []string{
+			"<p>Volunteers at a community orchard have brought back",
+			"<p>Some of the varieties had not been grown commercially",
+			"<p>The orchard will hold its first apple day in October",
+		} {
+			if !strings.Contains(content, required) {
+				t.Errorf("Expected content of %s %q to contain %q", fixture, content, required)
+			}
+		}
+
+		for _, excluded := range []string{"Food", "Copyright", "html:", "share.js"} {
+			if strings.Contains(content, excluded) {
+				t.Errorf("Did not expect content of %s %q to contain %q", fixture, content, excluded)
+			}
+		}
+	}
+}
+
+func TestJunkPhrases(t *testing.T) {
+	input := `<html><body><div class="article-body">
+<p>The new bridge over the river opened to traffic on Monday morning, two years later than planned and several million pounds over budget, but to the relief of drivers who have faced long diversions.</p>
+<p> advertisement </p>
+<p>Engineers say the delays were caused by flooding during construction, which washed away part of the temporary works twice and forced the contractor to redesign the foundations of the central pier.</p>
+<div><span>Sign up for our
+newsletter</span></div>
+<p>Local businesses welcomed the opening, saying the diversions had cost them customers, and the council said it would now begin work on the cycle path that will run alongside the road.</p>
+<p>Read more: our advertisement policy</p>
+</div></body></html>`
+
+	doc, err := NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if content := doc.Content(); !strings.Contains(content, "advertisement") || !strings.Contains(content, "newsletter") {
+		t.Errorf("Expected content %q to keep everything by default", content)
+	}
+
+	doc, err = NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.JunkPhrases = []string{"Advertisement", "Sign up for our newsletter", "Share this story"}
+	content := doc.Content()
+
+	for _, excluded := range []string{"<p> advertisement </p>", "newsletter"} {
+		if strings.Contains(content, excluded) {
+			t.Errorf("Did not expect content %q to contain %q", content, excluded)
+		}
+	}
+
+	if !strings.Contains(content, "Read more: our advertisement policy") {
+		t.Errorf("Expected content %q to keep paragraphs that only mention a junk phrase", content)
+	}
+}
+
+func TestMediaCounts(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/media_rich.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/media_rich.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.MinImageWidth = 50
+	images, videos, embeds := doc.MediaCounts()
+
+	// the logo is outside the article and the tracking pixel too small
+	if images != 2 {
+		t.Errorf("Expected 2 images, got %d", images)
+	}
+
+	if videos != 2 {
+		t.Errorf("Expected 2 videos, got %d", videos)
+	}
+
+	// the map and the tweet
+	if embeds != 2 {
+		t.Errorf("Expected 2 embeds, got %d", embeds)
+	}
+}
+
+func TestPreserveDirection(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/rtl.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/rtl.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if dir := doc.Direction(); dir != "rtl" {
+		t.Errorf("Expected the direction to be rtl, got %q", dir)
+	}
+
+	if content := doc.Content(); strings.Contains(content, "dir=") {
+		t.Errorf("Expected content %q to have no dir attributes by default", content)
+	}
+
+	doc, err = NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.PreserveDirection = true
+	content := doc.Content()
+
+	if !strings.Contains(content, `<body><div dir="rtl">`) {
+		t.Errorf("Expected content %q to be marked right to left", content)
+	}
+
+	if !strings.Contains(content, `<p dir="ltr">&#34;A library is the heart of a city,&#34;`) {
+		t.Errorf("Expected content %q to keep the direction of the quote", content)
+	}
+
+	doc, err = NewDocument(`<html dir="ltr"><body><p>مرحبا بالعالم</p></body></html>`)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if dir := doc.Direction(); dir != "ltr" {
+		t.Errorf("Expected the page's dir attribute to be used, got %q", dir)
+	}
+}
+
+func TestStripTrackingParams(t *testing.T) {
+	input := `<html><body><div class="article">
+	<p>The council opened a new public library in the centre of town on Monday, after years of planning and
+	building. It holds more than a hundred thousand books, study rooms and a space set aside for children.</p>
+	<p>Read the <a dir="ltr" href="https://example.com/plans?id=7&utm_source=news&amp;utm_medium=email&fbclid=abc#map">full plans</a>
+	published by the council, which include branches in the outer districts over the coming years.</p>
+	</div></body></html>`
+
+	doc, err := NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.WhitelistTags = append(doc.WhitelistTags, "a")
+	doc.StripTrackingParams = true
+	content := doc.Content()
+
+	if !strings.Contains(content, `<a href="https://example.com/plans?id=7#map">full plans</a>`) {
+		t.Errorf("Expected content %q to link without tracking parameters", content)
+	}
+
+	// whatever else is kept with the link
+	doc, err = NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.WhitelistTags = append(doc.WhitelistTags, "a")
+	doc.PreserveDirection = true
+	doc.StripTrackingParams = true
+	content = doc.Content()
+
+	if !strings.Contains(content, `<a href="https://example.com/plans?id=7#map" dir="ltr">full plans</a>`) {
+		t.Errorf("Expected content %q to link without tracking parameters", content)
+	}
+
+	doc, err = NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.WhitelistTags = append(doc.WhitelistTags, "a")
+	doc.StripTrackingParams = true
+	doc.TrackingParams = []string{"id"}
+	content = doc.Content()
+
+	if !strings.Contains(content, `<a href="https://example.com/plans?utm_source=news&amp;utm_medium=email&amp;fbclid=abc#map">`) {
+		t.Errorf("Expected content %q to strip only the configured parameters", content)
+	}
+
+	doc, err = NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.WhitelistTags = append(doc.WhitelistTags, "a")
+	content = doc.Content()
+
+	if !strings.Contains(content, `<a href="https://example.com/plans?id=7&amp;utm_source=news&amp;utm_medium=email&amp;fbclid=abc#map">`) {
+		t.Errorf("Expected content %q to keep tracking parameters by default", content)
+	}
+
+	doc, err = NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.StripTrackingParams = true
+	if content := doc.Content(); strings.Contains(content, "href") {
+		t.Errorf("Expected content %q to drop links unless they are whitelisted", content)
+	}
+}
+
+func TestSubtitle(t *testing.T) {
+	bytes, err := ioutil.ReadFile("test_fixtures/deck.html")
+	if err != nil {
+		t.Fatal("Unable to read file test_fixtures/deck.html", err)
+	}
+
+	doc, err := NewDocument(string(bytes))
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	deck := "The new building holds more than a hundred thousand books, and it will open seven days a week."
+	if subtitle := doc.Subtitle(); subtitle != deck {
+		t.Errorf("Expected subtitle %q, got %q", deck, subtitle)
+	}
+
+	doc.IncludeTitleInBody = true
+	doc.IncludeSubtitleInBody = true
+	content := doc.Content()
+
+	expected := `<body><h1>Town opens its first public library in fifty years</h1><p class="lead">` + deck + `</p>`
+	if !strings.Contains(content, expected) {
+		t.Errorf("Expected content %q to start with the title and subtitle", content)
+	}
+
+	if strings.Count(content, deck) != 1 {
+		t.Errorf("Expected content %q to include the subtitle once", content)
+	}
+
+	doc, err = NewDocument(`<html><head><meta itemprop="alternativeHeadline" content=" A deck from the metadata "></head><body><p>Text</p></body></html>`)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if subtitle := doc.Subtitle(); subtitle != "A deck from the metadata" {
+		t.Errorf("Expected the subtitle from the metadata, got %q", subtitle)
+	}
+
+	doc, err = NewDocument(`<html><body><h1>Title</h1><p>Text</p></body></html>`)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if subtitle := doc.Subtitle(); subtitle != "" {
+		t.Errorf("Expected no subtitle, got %q", subtitle)
+	}
+}
+
+func TestPreserveNonBreakingSpaces(t *testing.T) {
+	input := `<html><body><div><p>The route is 10&nbsp;km long and runs along the river past the old mill and
+	through the woods to the village green, where it finishes at the pub.&nbsp;&nbsp;&nbsp;The walk takes about three
+	hours at an easy pace, &nbsp;and is suitable for families with older children who are used to walking.</p></div></body></html>`
+
+	doc, err := NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	if text := doc.Text(); strings.Contains(text, "\u00a0") || !strings.Contains(text, "10 km") {
+		t.Errorf("Expected text %q to have only plain spaces by default", text)
+	}
+
+	doc, err = NewDocument(input)
+	if err != nil {
+		t.Fatal("Unable to create document", err)
+	}
+
+	doc.PreserveNonBreakingSpaces = true
+	content := doc.Content()
+
+	if !strings.Contains(content, "10\u00a0km") {
+		t.Errorf("Expected content %q to keep the non-breaking space", content)
+	}
+
+	if !strings.Contains(content, "pub. The walk") || !strings.Contains(content, "pace, and") {
+		t.Errorf("Expected content %q to collapse runs of non-breaking spaces", content)
+	}
+
+	text := doc.Text()
+	if !strings.Contains(text, "10\u00a0km") || strings.Count(text, "\u00a0") != 1 {
+		t.Errorf("Expected text %q to keep only the lone non-breaking space", text)
+	}
+}
@@ -0,0 +1,108 @@
+// Package readabilitytest provides helpers for testing extraction against
+// golden files, so adding a site only takes a fixture and its expected text.
+package readabilitytest
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mauidude/go-readability"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with the extracted text")
+
+// GoldenPath returns the path of the golden file for the fixture at path, which
+// sits alongside it with a .txt extension.
+func GoldenPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".txt"
+}
+
+// Golden extracts the text of the HTML fixture at path, configured by opts,
+// and fails t with a line by line diff if it doesn't match the golden file.
+// Run the tests with -update to write the golden files instead.
+func Golden(t testing.TB, path string, opts ...readability.Option) {
+	t.Helper()
+
+	input, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unable to read file %s: %v", path, err)
+	}
+
+	doc, err := readability.NewDocument(string(input))
+	if err != nil {
+		t.Fatalf("Unable to create document from %s: %v", path, err)
+	}
+
+	for _, opt := range opts {
+		opt(doc)
+	}
+
+	got := doc.Text() + "\n"
+	golden := GoldenPath(path)
+
+	if *update {
+		if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatalf("Unable to write golden file %s: %v", golden, err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("Unable to read golden file %s, run with -update to create it: %v", golden, err)
+	}
+
+	if got != string(want) {
+		t.Errorf("Text of %s does not match %s (-want +got):\n%s", path, golden, Diff(string(want), got))
+	}
+}
+
+// Diff returns a line by line diff of want and got, with removed lines
+// prefixed by "-", added lines by "+" and unchanged lines by a space.
+func Diff(want, got string) string {
+	a := strings.Split(want, "\n")
+	b := strings.Split(got, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var output bytes.Buffer
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintf(&output, " %s\n", a[i])
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&output, "-%s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(&output, "+%s\n", b[j])
+			j++
+		}
+	}
+
+	return output.String()
+}
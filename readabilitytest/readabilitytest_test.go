@@ -0,0 +1,34 @@
+package readabilitytest
+
+import (
+	"testing"
+
+	"github.com/mauidude/go-readability"
+)
+
+func TestGolden(t *testing.T) {
+	Golden(t, "../test_fixtures/sibling_order.html")
+	Golden(t, "../test_fixtures/footnotes.html", func(d *readability.Document) {
+		d.PreserveFootnotes = true
+	})
+}
+
+func TestDiff(t *testing.T) {
+	want := "first\nsecond\nthird"
+	got := "first\n2nd\nthird\nfourth"
+
+	expected := " first\n-second\n+2nd\n third\n+fourth\n"
+	if diff := Diff(want, got); diff != expected {
+		t.Errorf("Expected diff %q, got %q", expected, diff)
+	}
+
+	if diff := Diff(want, want); diff != " first\n second\n third\n" {
+		t.Errorf("Expected no changes in diff %q", diff)
+	}
+}
+
+func TestGoldenPath(t *testing.T) {
+	if path := GoldenPath("../test_fixtures/recipe.html"); path != "../test_fixtures/recipe.txt" {
+		t.Errorf("Expected golden path ../test_fixtures/recipe.txt, got %s", path)
+	}
+}